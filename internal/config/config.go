@@ -0,0 +1,451 @@
+// Package config loads the server configuration.
+//
+// Every setting has a default and can be overridden, in increasing order of
+// precedence, by an optional YAML file, an environment variable and a
+// command line flag. The file is named by --config or CONFIG_FILE. The names
+// of the variable and the flag for each setting are given by the env and flag
+// struct tags below.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lahaehae/crud_project/internal/currency"
+	"gopkg.in/yaml.v3"
+)
+
+// Storage backends.
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+)
+
+type Config struct {
+	Storage     Storage     `yaml:"storage"`
+	HTTP        HTTP        `yaml:"http"`
+	DB          DB          `yaml:"db"`
+	OTel        OTel        `yaml:"otel"`
+	Idempotency Idempotency `yaml:"idempotency"`
+	Transfers   Transfers   `yaml:"transfers"`
+	FX          FX          `yaml:"fx"`
+	Holds       Holds       `yaml:"holds"`
+	Scheduler   Scheduler   `yaml:"scheduler"`
+	Limits      Limits      `yaml:"limits"`
+	Admin       Admin       `yaml:"admin"`
+	Health      Health      `yaml:"health"`
+	Features    Features    `yaml:"features"`
+}
+
+type Storage struct {
+	Backend string `yaml:"backend" env:"STORAGE" flag:"storage" usage:"where data is kept: postgres or memory"`
+}
+
+type HTTP struct {
+	Addr              string        `yaml:"addr" env:"HTTP_ADDR" flag:"http-addr" usage:"address the HTTP server listens on"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT" flag:"http-read-header-timeout" usage:"time allowed to read request headers"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"HTTP_READ_TIMEOUT" flag:"http-read-timeout" usage:"time allowed to read a whole request"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"HTTP_WRITE_TIMEOUT" flag:"http-write-timeout" usage:"time allowed to write a response"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT" flag:"http-idle-timeout" usage:"how long idle keep-alive connections are kept"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT" flag:"http-shutdown-timeout" usage:"time allowed for in-flight requests to finish on shutdown"`
+}
+
+type DB struct {
+	URL             string        `yaml:"url" env:"DATABASE_URL" flag:"db-url" usage:"Postgres connection string" secret:"true"`
+	MaxConns        int32         `yaml:"max_conns" env:"DB_MAX_CONNS" flag:"db-max-conns" usage:"maximum number of pooled connections"`
+	MinConns        int32         `yaml:"min_conns" env:"DB_MIN_CONNS" flag:"db-min-conns" usage:"minimum number of idle pooled connections"`
+	MaxConnLifetime time.Duration `yaml:"max_conn_lifetime" env:"DB_MAX_CONN_LIFETIME" flag:"db-max-conn-lifetime" usage:"maximum age of a pooled connection"`
+	MaxConnIdleTime time.Duration `yaml:"max_conn_idle_time" env:"DB_MAX_CONN_IDLE_TIME" flag:"db-max-conn-idle-time" usage:"how long a connection may stay idle in the pool"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout" env:"DB_CONNECT_TIMEOUT" flag:"db-connect-timeout" usage:"time allowed to connect to the database at startup"`
+	TxIsolation     string        `yaml:"tx_isolation" env:"DB_TX_ISOLATION" flag:"db-tx-isolation" usage:"isolation level of database transactions: read_committed, repeatable_read or serializable"`
+	TxMaxAttempts   int           `yaml:"tx_max_attempts" env:"DB_TX_MAX_ATTEMPTS" flag:"db-tx-max-attempts" usage:"attempts for a transaction hitting serialization failures or deadlocks"`
+	TxRetryDelay    time.Duration `yaml:"tx_retry_delay" env:"DB_TX_RETRY_DELAY" flag:"db-tx-retry-delay" usage:"base of the jittered exponential backoff between transaction attempts"`
+	TxRetryMaxDelay time.Duration `yaml:"tx_retry_max_delay" env:"DB_TX_RETRY_MAX_DELAY" flag:"db-tx-retry-max-delay" usage:"upper bound of the backoff between transaction attempts"`
+	MigrateOnStart  bool          `yaml:"migrate_on_start" env:"MIGRATE_ON_START" flag:"migrate-on-start" usage:"apply pending migrations at startup"`
+}
+
+type OTel struct {
+	Endpoint       string        `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" flag:"otel-endpoint" usage:"OTLP collector endpoint, host:port or URL"`
+	Protocol       string        `yaml:"protocol" env:"OTEL_EXPORTER_OTLP_PROTOCOL" flag:"otel-protocol" usage:"OTLP protocol: http (http/protobuf) or grpc"`
+	Insecure       bool          `yaml:"insecure" env:"OTEL_EXPORTER_OTLP_INSECURE" flag:"otel-insecure" usage:"disable TLS towards the collector"`
+	SamplingRatio  float64       `yaml:"sampling_ratio" env:"OTEL_TRACES_SAMPLER_ARG" flag:"otel-sampling-ratio" usage:"fraction of new traces that are sampled"`
+	ServiceName    string        `yaml:"service_name" env:"OTEL_SERVICE_NAME" flag:"otel-service-name" usage:"service.name resource attribute"`
+	ServiceVersion string        `yaml:"service_version" env:"SERVICE_VERSION" flag:"otel-service-version" usage:"service.version resource attribute"`
+	InstanceID     string        `yaml:"instance_id" env:"SERVICE_INSTANCE_ID" flag:"otel-instance-id" usage:"service.instance.id resource attribute (default: hostname)"`
+	FlushTimeout   time.Duration `yaml:"flush_timeout" env:"OTEL_FLUSH_TIMEOUT" flag:"otel-flush-timeout" usage:"time allowed to flush spans and metrics on shutdown"`
+}
+
+type Idempotency struct {
+	TTL             time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL" flag:"idempotency-ttl" usage:"how long idempotency keys are remembered"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval" env:"IDEMPOTENCY_CLEANUP_INTERVAL" flag:"idempotency-cleanup-interval" usage:"how often expired idempotency keys are deleted"`
+}
+
+type Transfers struct {
+	MaxAmount int64 `yaml:"max_amount" env:"MAX_TRANSFER_AMOUNT" flag:"max-transfer-amount" usage:"largest amount allowed in a single transfer"`
+}
+
+type FX struct {
+	Rates string `yaml:"rates" env:"FX_RATES" flag:"fx-rates" usage:"exchange rates for transfers between currencies, e.g. USD/EUR=0.92,USD/RUB=90.5; inverse pairs are derived"`
+}
+
+type Holds struct {
+	TTL           time.Duration `yaml:"ttl" env:"HOLD_TTL" flag:"hold-ttl" usage:"how long a hold reserves funds before it expires"`
+	SweepInterval time.Duration `yaml:"sweep_interval" env:"HOLD_SWEEP_INTERVAL" flag:"hold-sweep-interval" usage:"how often expired holds are released"`
+}
+
+type Scheduler struct {
+	Enabled      bool          `yaml:"enabled" env:"SCHEDULER_ENABLED" flag:"scheduler-enabled" usage:"run due scheduled transfers in this process"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"SCHEDULER_POLL_INTERVAL" flag:"scheduler-poll-interval" usage:"how often due scheduled transfers are looked for"`
+	BatchSize    int           `yaml:"batch_size" env:"SCHEDULER_BATCH_SIZE" flag:"scheduler-batch-size" usage:"most scheduled transfers run per poll"`
+	MaxAttempts  int           `yaml:"max_attempts" env:"SCHEDULER_MAX_ATTEMPTS" flag:"scheduler-max-attempts" usage:"attempts at a scheduled transfer before it is given up"`
+	RetryDelay   time.Duration `yaml:"retry_delay" env:"SCHEDULER_RETRY_DELAY" flag:"scheduler-retry-delay" usage:"wait before retrying a failed scheduled transfer, doubled per attempt"`
+}
+
+// Limits are the transfer limits of users without overrides; zero means
+// unlimited. The amounts are in minor units of Currency.
+type Limits struct {
+	Currency      string `yaml:"currency" env:"LIMIT_CURRENCY" flag:"limit-currency" usage:"currency of the limit amounts; accounts in other currencies get them converted at the fx rate, and cannot send while it is missing"`
+	MaxAmount     int64  `yaml:"max_amount" env:"LIMIT_MAX_AMOUNT" flag:"limit-max-amount" usage:"largest single transfer a user may send, 0 for no limit"`
+	DailyAmount   int64  `yaml:"daily_amount" env:"LIMIT_DAILY_AMOUNT" flag:"limit-daily-amount" usage:"most a user may send per UTC day, 0 for no limit"`
+	MonthlyAmount int64  `yaml:"monthly_amount" env:"LIMIT_MONTHLY_AMOUNT" flag:"limit-monthly-amount" usage:"most a user may send per UTC month, 0 for no limit"`
+	HourlyCount   int64  `yaml:"hourly_count" env:"LIMIT_HOURLY_COUNT" flag:"limit-hourly-count" usage:"most transfers a user may send per hour, 0 for no limit"`
+}
+
+type Admin struct {
+	Token string `yaml:"token" env:"ADMIN_TOKEN" flag:"admin-token" usage:"bearer token of the admin API, which is disabled when empty" secret:"true"`
+}
+
+type Health struct {
+	CheckTimeout time.Duration `yaml:"check_timeout" env:"HEALTH_CHECK_TIMEOUT" flag:"health-check-timeout" usage:"time allowed for a single health check"`
+	DrainDelay   time.Duration `yaml:"drain_delay" env:"HEALTH_DRAIN_DELAY" flag:"health-drain-delay" usage:"how long /readyz reports draining before the server stops accepting connections"`
+}
+
+type Features struct {
+	Idempotency    bool `yaml:"idempotency" env:"FEATURE_IDEMPOTENCY" flag:"feature-idempotency" usage:"honour Idempotency-Key headers"`
+	RequireIfMatch bool `yaml:"require_if_match" env:"REQUIRE_IF_MATCH" flag:"require-if-match" usage:"refuse writes to users without If-Match"`
+}
+
+// Default returns the configuration used when nothing is overridden.
+func Default() Config {
+	instanceID, _ := os.Hostname()
+	return Config{
+		Storage: Storage{
+			Backend: StoragePostgres,
+		},
+		HTTP: HTTP{
+			Addr:              "0.0.0.0:8080",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		DB: DB{
+			MaxConns:        10,
+			MinConns:        0,
+			MaxConnLifetime: time.Hour,
+			MaxConnIdleTime: 30 * time.Minute,
+			ConnectTimeout:  5 * time.Second,
+			TxIsolation:     "read_committed",
+			TxMaxAttempts:   5,
+			TxRetryDelay:    10 * time.Millisecond,
+			TxRetryMaxDelay: 500 * time.Millisecond,
+		},
+		OTel: OTel{
+			Endpoint:       "otel-collector:4318",
+			Protocol:       "http",
+			Insecure:       true,
+			SamplingRatio:  1,
+			ServiceName:    "rest-service",
+			ServiceVersion: "1.0.0",
+			InstanceID:     instanceID,
+			FlushTimeout:   5 * time.Second,
+		},
+		Idempotency: Idempotency{
+			TTL:             24 * time.Hour,
+			CleanupInterval: time.Hour,
+		},
+		Transfers: Transfers{
+			MaxAmount: 100_000_000,
+		},
+		Holds: Holds{
+			TTL:           7 * 24 * time.Hour,
+			SweepInterval: time.Minute,
+		},
+		Scheduler: Scheduler{
+			Enabled:      true,
+			PollInterval: 10 * time.Second,
+			BatchSize:    100,
+			MaxAttempts:  5,
+			RetryDelay:   time.Minute,
+		},
+		Limits: Limits{
+			Currency: currency.Default,
+		},
+		Health: Health{
+			CheckTimeout: 2 * time.Second,
+		},
+		Features: Features{
+			Idempotency: true,
+		},
+	}
+}
+
+// Options are the command line switches that are not configuration values.
+type Options struct {
+	PrintConfig bool
+}
+
+// Load builds the configuration from args (without the program name), the
+// environment and the optional config file, then validates it unless only
+// printing was requested.
+func Load(args []string) (*Config, Options, error) {
+	var opts Options
+	cfg := Default()
+
+	fs := flag.NewFlagSet("userapi", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective configuration with secrets redacted and exit")
+	fields := settings(&cfg)
+	for _, f := range fields {
+		usage := f.usage + " [$" + f.env + "]"
+		if f.value.Kind() == reflect.Bool {
+			fs.Bool(f.flag, f.value.Bool(), usage)
+		} else {
+			fs.String(f.flag, "", usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, opts, err
+	}
+	if fs.NArg() > 0 {
+		return nil, opts, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	if *configFile != "" {
+		if err := loadFile(&cfg, *configFile); err != nil {
+			return nil, opts, err
+		}
+	}
+
+	for _, f := range fields {
+		if v, ok := os.LookupEnv(f.env); ok {
+			if err := f.set(v); err != nil {
+				return nil, opts, fmt.Errorf("$%s: %w", f.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(fl *flag.Flag) {
+		for _, f := range fields {
+			if f.flag == fl.Name && flagErr == nil {
+				if err := f.set(fl.Value.String()); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", f.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, opts, flagErr
+	}
+
+	// an invalid configuration can still be printed to find out why
+	if !opts.PrintConfig {
+		if err := cfg.Validate(); err != nil {
+			return nil, opts, err
+		}
+	}
+	return &cfg, opts, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Storage.Backend == StoragePostgres || c.Storage.Backend == StorageMemory, "storage.backend must be postgres or memory")
+
+	check(c.HTTP.Addr != "", "http.addr must be set")
+	check(c.HTTP.ReadHeaderTimeout > 0, "http.read_header_timeout must be positive")
+	check(c.HTTP.ReadTimeout > 0, "http.read_timeout must be positive")
+	check(c.HTTP.WriteTimeout > 0, "http.write_timeout must be positive")
+	check(c.HTTP.IdleTimeout > 0, "http.idle_timeout must be positive")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout must be positive")
+
+	check(c.DB.URL != "" || c.Storage.Backend == StorageMemory, "db.url must be set")
+	check(c.DB.MaxConns > 0, "db.max_conns must be positive")
+	check(c.DB.MinConns >= 0 && c.DB.MinConns <= c.DB.MaxConns, "db.min_conns must be between 0 and db.max_conns")
+	check(c.DB.MaxConnLifetime > 0, "db.max_conn_lifetime must be positive")
+	check(c.DB.MaxConnIdleTime > 0, "db.max_conn_idle_time must be positive")
+	check(c.DB.ConnectTimeout > 0, "db.connect_timeout must be positive")
+	check(slices.Contains([]string{"read_committed", "repeatable_read", "serializable"}, c.DB.TxIsolation),
+		"db.tx_isolation must be read_committed, repeatable_read or serializable")
+	check(c.DB.TxMaxAttempts > 0, "db.tx_max_attempts must be positive")
+	check(c.DB.TxRetryDelay >= 0, "db.tx_retry_delay must not be negative")
+	check(c.DB.TxRetryMaxDelay >= c.DB.TxRetryDelay, "db.tx_retry_max_delay must be at least db.tx_retry_delay")
+
+	check(c.OTel.Endpoint != "", "otel.endpoint must be set")
+	check(c.OTel.Protocol == "http" || c.OTel.Protocol == "http/protobuf" || c.OTel.Protocol == "grpc",
+		"otel.protocol must be http, http/protobuf or grpc")
+	check(c.OTel.SamplingRatio >= 0 && c.OTel.SamplingRatio <= 1, "otel.sampling_ratio must be between 0 and 1")
+	check(c.OTel.ServiceName != "", "otel.service_name must be set")
+	check(c.OTel.FlushTimeout > 0, "otel.flush_timeout must be positive")
+
+	check(c.Idempotency.TTL > 0, "idempotency.ttl must be positive")
+	check(c.Idempotency.CleanupInterval > 0, "idempotency.cleanup_interval must be positive")
+
+	check(c.Transfers.MaxAmount > 0, "transfers.max_amount must be positive")
+
+	if _, err := currency.ParseStaticRates(c.FX.Rates); err != nil {
+		errs = append(errs, fmt.Errorf("fx.rates: %w", err))
+	}
+
+	check(c.Holds.TTL > 0, "holds.ttl must be positive")
+	check(c.Holds.SweepInterval > 0, "holds.sweep_interval must be positive")
+
+	check(c.Scheduler.PollInterval > 0, "scheduler.poll_interval must be positive")
+	check(c.Scheduler.BatchSize >= 1, "scheduler.batch_size must be at least 1")
+	check(c.Scheduler.MaxAttempts >= 1, "scheduler.max_attempts must be at least 1")
+	check(c.Scheduler.RetryDelay > 0, "scheduler.retry_delay must be positive")
+
+	_, ok := currency.Lookup(c.Limits.Currency)
+	check(ok, "limits.currency must be one of %s", strings.Join(currency.Codes(), ", "))
+	check(c.Limits.MaxAmount >= 0, "limits.max_amount must not be negative")
+	check(c.Limits.DailyAmount >= 0, "limits.daily_amount must not be negative")
+	check(c.Limits.MonthlyAmount >= 0, "limits.monthly_amount must not be negative")
+	check(c.Limits.HourlyCount >= 0, "limits.hourly_count must not be negative")
+
+	check(c.Health.CheckTimeout > 0, "health.check_timeout must be positive")
+	check(c.Health.DrainDelay >= 0, "health.drain_delay must not be negative")
+
+	return errors.Join(errs...)
+}
+
+// WriteRedacted writes the configuration as YAML with secrets masked.
+func (c *Config) WriteRedacted(w io.Writer) error {
+	redacted := *c
+	for _, f := range settings(&redacted) {
+		if f.secret && f.value.String() != "" {
+			f.value.SetString(redact(f.value.String()))
+		}
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(redacted); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// secretParams are the connection URL query parameters that carry secrets.
+var secretParams = []string{"password", "sslpassword"}
+
+// redact hides the password of a connection URL, whether in its user info or
+// its query, or the whole value when it is not a URL.
+func redact(v string) string {
+	u, err := url.Parse(v)
+	if err != nil || u.Scheme == "" || !strings.HasPrefix(v, u.Scheme+"://") {
+		return "REDACTED"
+	}
+	query := u.Query()
+	for _, p := range secretParams {
+		if query.Has(p) {
+			query.Set(p, "xxxxx")
+			u.RawQuery = query.Encode()
+		}
+	}
+	return u.Redacted()
+}
+
+// setting is a single leaf value of Config together with its tags.
+type setting struct {
+	flag   string
+	env    string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// settings lists the leaf values of cfg; setting them writes through to cfg.
+func settings(cfg *Config) []setting {
+	var out []setting
+	root := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Field(i)
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			out = append(out, setting{
+				flag:   field.Tag.Get("flag"),
+				env:    field.Tag.Get("env"),
+				usage:  field.Tag.Get("usage"),
+				secret: field.Tag.Get("secret") == "true",
+				value:  section.Field(j),
+			})
+		}
+	}
+	return out
+}
+
+func (s setting) set(raw string) error {
+	v := s.value
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int32 || v.Kind() == reflect.Int64 || v.Kind() == reflect.Int:
+		n, err := strconv.ParseInt(strings.ReplaceAll(raw, "_", ""), 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
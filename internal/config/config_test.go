@@ -0,0 +1,194 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes a config file into a fresh directory and returns its path.
+func writeFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `
+storage:
+  backend: memory
+http:
+  addr: 127.0.0.1:9000
+db:
+  max_conns: 20
+scheduler:
+  batch_size: 20
+  max_attempts: 20
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("DB_MAX_CONNS", "30")
+	t.Setenv("SCHEDULER_BATCH_SIZE", "30")
+
+	cfg, _, err := Load([]string{"--scheduler-batch-size=40"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"default", cfg.HTTP.ReadTimeout, Default().HTTP.ReadTimeout},
+		{"file over default", cfg.HTTP.Addr, "127.0.0.1:9000"},
+		{"file only", cfg.Scheduler.MaxAttempts, 20},
+		{"env over file", cfg.DB.MaxConns, int32(30)},
+		{"flag over env", cfg.Scheduler.BatchSize, 40},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{name: "unknown file key", file: "http:\n  adress: x\n", wantErr: "adress"},
+		{name: "missing file", args: []string{"--config", "/nonexistent/config.yaml"}, wantErr: "config file"},
+		{name: "bad env value", env: map[string]string{"DB_MAX_CONNS": "many"}, wantErr: "$DB_MAX_CONNS"},
+		{name: "bad flag value", args: []string{"--http-read-timeout=soon"}, wantErr: "-http-read-timeout"},
+		{name: "stray argument", args: []string{"serve"}, wantErr: "unexpected arguments"},
+		{name: "invalid result", args: []string{"--storage=postgres"}, wantErr: "db.url must be set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("STORAGE", StorageMemory)
+			t.Setenv("DATABASE_URL", "")
+			if tt.file != "" {
+				t.Setenv("CONFIG_FILE", writeFile(t, tt.file))
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, _, err := Load(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load: error %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadPrintConfigSkipsValidation(t *testing.T) {
+	t.Setenv("STORAGE", "nowhere")
+	_, opts, err := Load([]string{"--print-config"})
+	if err != nil || !opts.PrintConfig {
+		t.Fatalf("Load: print %v, error %v; want the invalid config to load for printing", opts.PrintConfig, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr []string
+	}{
+		{name: "valid", change: func(c *Config) {}},
+		{name: "postgres without url", change: func(c *Config) { c.Storage.Backend = StoragePostgres }, wantErr: []string{"db.url must be set"}},
+		{name: "unknown backend", change: func(c *Config) { c.Storage.Backend = "disk" }, wantErr: []string{"storage.backend"}},
+		{name: "min conns above max", change: func(c *Config) { c.DB.MinConns = 20 }, wantErr: []string{"db.min_conns"}},
+		{name: "isolation", change: func(c *Config) { c.DB.TxIsolation = "snapshot" }, wantErr: []string{"db.tx_isolation"}},
+		{name: "retry delays", change: func(c *Config) { c.DB.TxRetryMaxDelay = time.Millisecond }, wantErr: []string{"db.tx_retry_max_delay"}},
+		{name: "sampling ratio", change: func(c *Config) { c.OTel.SamplingRatio = 1.5 }, wantErr: []string{"otel.sampling_ratio"}},
+		{name: "fx rates", change: func(c *Config) { c.FX.Rates = "USD/EUR=zero" }, wantErr: []string{"fx.rates"}},
+		{name: "limits currency", change: func(c *Config) { c.Limits.Currency = "XXX" }, wantErr: []string{"limits.currency must be one of EUR, RUB, USD"}},
+		{name: "negative limit", change: func(c *Config) { c.Limits.DailyAmount = -1 }, wantErr: []string{"limits.daily_amount"}},
+		{
+			name: "every error at once",
+			change: func(c *Config) {
+				c.HTTP.Addr = ""
+				c.Transfers.MaxAmount = 0
+				c.Scheduler.BatchSize = 0
+			},
+			wantErr: []string{"http.addr", "transfers.max_amount", "scheduler.batch_size"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Storage.Backend = StorageMemory
+			tt.change(&cfg)
+
+			err := cfg.Validate()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate succeeded, want %v", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate: error %q, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"postgres://user:s3cret@db:5432/app?sslmode=disable", "postgres://user:xxxxx@db:5432/app?sslmode=disable"},
+		{"postgres://user@db/app?password=s3cret", "postgres://user@db/app?password=xxxxx"},
+		{"postgres://db/app?sslmode=require&sslpassword=s3cret", "postgres://db/app?sslmode=require&sslpassword=xxxxx"},
+		{"postgres://user:s3cret@db/app?password=s3cret", "postgres://user:xxxxx@db/app?password=xxxxx"},
+		{"postgres://db/app", "postgres://db/app"},
+		{"host=db user=user password=s3cret", "REDACTED"},
+		{"s3cret", "REDACTED"},
+		{"token:s3cret", "REDACTED"},
+	}
+	for _, tt := range tests {
+		if got := redact(tt.value); got != tt.want {
+			t.Errorf("redact(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestWriteRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.DB.URL = "postgres://user:s3cret@db/app?password=s3cret"
+	cfg.Admin.Token = "s3cret"
+
+	var out bytes.Buffer
+	if err := cfg.WriteRedacted(&out); err != nil {
+		t.Fatalf("WriteRedacted: %v", err)
+	}
+	if strings.Contains(out.String(), "s3cret") {
+		t.Errorf("output leaks a secret:\n%s", out.String())
+	}
+	for _, want := range []string{"url: postgres://user:xxxxx@db/app?password=xxxxx", "token: REDACTED", "addr: 0.0.0.0:8080"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+	// the configuration itself keeps its secrets
+	if cfg.Admin.Token != "s3cret" || !strings.Contains(cfg.DB.URL, "s3cret") {
+		t.Errorf("WriteRedacted changed the configuration: %+v, %+v", cfg.Admin, cfg.DB)
+	}
+}
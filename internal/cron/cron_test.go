@@ -0,0 +1,94 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"@never",
+	}
+	for _, expr := range tests {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name  string
+		expr  string
+		after string
+		want  string
+	}{
+		{"every minute", "* * * * *", "2024-01-01T10:15:30Z", "2024-01-01T10:16:00Z"},
+		{"strictly after", "15 10 * * *", "2024-01-01T10:15:00Z", "2024-01-02T10:15:00Z"},
+		{"list", "5,35 * * * *", "2024-01-01T10:06:00Z", "2024-01-01T10:35:00Z"},
+		{"range", "0 9-17 * * *", "2024-01-01T17:30:00Z", "2024-01-02T09:00:00Z"},
+		{"stepped star", "*/20 * * * *", "2024-01-01T10:41:00Z", "2024-01-01T11:00:00Z"},
+		{"stepped range", "10-40/15 * * * *", "2024-01-01T10:26:00Z", "2024-01-01T10:40:00Z"},
+		{"stepped value runs to the end", "50/5 * * * *", "2024-01-01T10:51:00Z", "2024-01-01T10:55:00Z"},
+		{"hour rollover", "0 * * * *", "2024-01-01T23:59:00Z", "2024-01-02T00:00:00Z"},
+		{"month rollover", "0 0 1 * *", "2024-01-31T12:00:00Z", "2024-02-01T00:00:00Z"},
+		{"year rollover", "@yearly", "2024-06-01T00:00:00Z", "2025-01-01T00:00:00Z"},
+		{"skips short months", "0 0 31 * *", "2024-04-01T00:00:00Z", "2024-05-31T00:00:00Z"},
+		{"leap day", "0 0 29 2 *", "2025-01-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"day of week", "0 12 * * 1", "2024-01-03T00:00:00Z", "2024-01-08T12:00:00Z"},
+		{"sunday as 7", "0 0 * * 7", "2024-01-01T00:00:00Z", "2024-01-07T00:00:00Z"},
+		{"weekday range", "0 8 * * 1-5", "2024-01-05T09:00:00Z", "2024-01-08T08:00:00Z"},
+		// 2024-01-10 is a Wednesday, neither the 15th nor a Monday
+		{"both days restricted match either", "0 0 15 * 1", "2024-01-10T00:00:00Z", "2024-01-15T00:00:00Z"},
+		{"both days restricted, day of week first", "0 0 20 * 5", "2024-01-10T00:00:00Z", "2024-01-12T00:00:00Z"},
+		// a field starting with * restricts nothing, so both must match: Mondays
+		// on odd days, and 10ths falling on a Sunday, Wednesday or Saturday
+		{"stepped day of month is starred", "0 0 */2 * 1", "2024-01-01T00:00:00Z", "2024-01-15T00:00:00Z"},
+		{"stepped day of week is starred", "0 0 10 * */3", "2024-01-10T00:00:00Z", "2024-02-10T00:00:00Z"},
+		{"month restricted", "0 0 1 3,9 *", "2024-03-01T00:00:00Z", "2024-09-01T00:00:00Z"},
+		{"converts to UTC", "0 0 * * *", "2024-01-01T21:30:00-02:00", "2024-01-02T00:00:00Z"},
+		{"macro", "@hourly", "2024-01-01T10:00:00Z", "2024-01-01T11:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+			if got, want := s.Next(at(tt.after)), at(tt.want); !got.Equal(want) {
+				t.Errorf("%q after %s = %s, want %s", tt.expr, tt.after, got.Format(time.RFC3339), tt.want)
+			}
+		})
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("February 30th matched %s", got)
+	}
+}
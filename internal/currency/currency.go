@@ -0,0 +1,193 @@
+// Package currency knows the currencies accounts can be held in and converts
+// amounts between them.
+//
+// Amounts are always integers in the currency's minor unit (cents, kopecks),
+// so converting between currencies with different exponents also rescales.
+// Exchange rates are exact rationals and are written as decimal strings
+// wherever they are stored or shown.
+package currency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// ErrNoRate is returned by a RateProvider that cannot quote a pair.
+var ErrNoRate = errors.New("no exchange rate")
+
+// ErrOutOfRange is returned by Convert when the converted amount does not
+// fit into an int64.
+var ErrOutOfRange = errors.New("converted amount out of range")
+
+// Currency is an ISO 4217 currency with the number of digits of its minor
+// unit.
+type Currency struct {
+	Code     string
+	Exponent int
+}
+
+// Default is the currency of accounts created without naming one.
+const Default = "USD"
+
+var supported = map[string]Currency{
+	"USD": {Code: "USD", Exponent: 2},
+	"EUR": {Code: "EUR", Exponent: 2},
+	"RUB": {Code: "RUB", Exponent: 2},
+}
+
+// Lookup returns the supported currency with the given code.
+func Lookup(code string) (Currency, bool) {
+	c, ok := supported[code]
+	return c, ok
+}
+
+// Codes lists the supported currency codes in alphabetical order.
+func Codes() []string {
+	codes := make([]string, 0, len(supported))
+	for code := range supported {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// RateProvider quotes how many units of to one unit of from buys.
+type RateProvider interface {
+	Rate(ctx context.Context, from, to string) (*big.Rat, error)
+}
+
+// RatePrecision is the number of fractional digits a rate is applied and
+// recorded with.
+const RatePrecision = 10
+
+// Quote is a rate between two currencies, rounded to RatePrecision so that
+// the rate recorded on a transfer is exactly the one that was applied.
+type Quote struct {
+	From Currency
+	To   Currency
+	Rate *big.Rat
+}
+
+// NewQuote rounds rate and returns the quote for converting from into to.
+func NewQuote(from, to Currency, rate *big.Rat) (Quote, error) {
+	rounded, err := ParseRate(rate.FloatString(RatePrecision))
+	if err != nil {
+		return Quote{}, fmt.Errorf("%s/%s: %w", from.Code, to.Code, err)
+	}
+	return Quote{From: from, To: to, Rate: rounded}, nil
+}
+
+// Convert converts amount minor units of q.From into minor units of q.To.
+func (q Quote) Convert(amount int64) (int64, error) {
+	return Convert(amount, q.From, q.To, q.Rate)
+}
+
+// Convert converts amount minor units of from into minor units of to at
+// rate, rounding down so that a conversion never creates money.
+func Convert(amount int64, from, to Currency, rate *big.Rat) (int64, error) {
+	v := new(big.Rat).Mul(big.NewRat(amount, 1), rate)
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(to.Exponent-from.Exponent))), nil)
+	if to.Exponent >= from.Exponent {
+		v.Mul(v, new(big.Rat).SetInt(scale))
+	} else {
+		v.Quo(v, new(big.Rat).SetInt(scale))
+	}
+	converted := new(big.Int).Quo(v.Num(), v.Denom())
+	if !converted.IsInt64() {
+		return 0, fmt.Errorf("%w: %d %s in %s", ErrOutOfRange, amount, from.Code, to.Code)
+	}
+	return converted.Int64(), nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// FormatRate writes rate as a decimal with at most RatePrecision fractional
+// digits.
+func FormatRate(rate *big.Rat) string {
+	s := rate.FloatString(RatePrecision)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// ParseRate parses a positive decimal rate.
+func ParseRate(s string) (*big.Rat, error) {
+	rate, ok := new(big.Rat).SetString(s)
+	if !ok || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid exchange rate %q", s)
+	}
+	return rate, nil
+}
+
+// StaticRates is a RateProvider backed by a fixed table; the inverse of
+// every listed pair is quoted as well.
+type StaticRates struct {
+	rates map[[2]string]*big.Rat
+}
+
+var _ RateProvider = (*StaticRates)(nil)
+
+// NewStaticRates builds the table from "FROM/TO" keys.
+func NewStaticRates(rates map[string]*big.Rat) (*StaticRates, error) {
+	s := &StaticRates{rates: map[[2]string]*big.Rat{}}
+	for pair, rate := range rates {
+		from, to, ok := strings.Cut(pair, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid currency pair %q", pair)
+		}
+		for _, code := range []string{from, to} {
+			if _, ok := Lookup(code); !ok {
+				return nil, fmt.Errorf("unsupported currency %q in pair %q", code, pair)
+			}
+		}
+		if rate.Sign() <= 0 {
+			return nil, fmt.Errorf("rate of %s must be positive", pair)
+		}
+		s.rates[[2]string{from, to}] = rate
+		if _, ok := rates[to+"/"+from]; !ok {
+			s.rates[[2]string{to, from}] = new(big.Rat).Inv(rate)
+		}
+	}
+	return s, nil
+}
+
+// ParseStaticRates builds the table from a list such as
+// "USD/EUR=0.92,USD/RUB=90.5".
+func ParseStaticRates(list string) (*StaticRates, error) {
+	rates := map[string]*big.Rat{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pair, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate %q, want FROM/TO=RATE", item)
+		}
+		rate, err := ParseRate(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		rates[strings.ToUpper(strings.TrimSpace(pair))] = rate
+	}
+	return NewStaticRates(rates)
+}
+
+func (s *StaticRates) Rate(ctx context.Context, from, to string) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+	rate, ok := s.rates[[2]string{from, to}]
+	if !ok {
+		return nil, fmt.Errorf("%w for %s/%s", ErrNoRate, from, to)
+	}
+	return new(big.Rat).Set(rate), nil
+}
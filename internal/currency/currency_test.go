@@ -0,0 +1,139 @@
+package currency
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestParseStaticRatesErrors(t *testing.T) {
+	tests := []string{
+		"USD/EUR",
+		"USD/EUR=",
+		"USD/EUR=abc",
+		"USD/EUR=0",
+		"USD/EUR=-1",
+		"USDEUR=1",
+		"USD/XXX=1",
+		"USD/EUR=0.5,EUR/RUB",
+	}
+	for _, list := range tests {
+		if _, err := ParseStaticRates(list); err == nil {
+			t.Errorf("ParseStaticRates(%q) succeeded, want an error", list)
+		}
+	}
+}
+
+func TestStaticRates(t *testing.T) {
+	rates, err := ParseStaticRates(" usd/eur = 0.5 , USD/RUB=90.5,RUB/USD=0.011")
+	if err != nil {
+		t.Fatalf("ParseStaticRates: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+		wantErr  error
+	}{
+		{name: "listed", from: "USD", to: "EUR", want: "1/2"},
+		{name: "inverse", from: "EUR", to: "USD", want: "2"},
+		{name: "listed both ways", from: "RUB", to: "USD", want: "11/1000"},
+		{name: "same currency", from: "RUB", to: "RUB", want: "1"},
+		{name: "missing pair", from: "EUR", to: "RUB", wantErr: ErrNoRate},
+		{name: "unknown currency", from: "USD", to: "GBP", wantErr: ErrNoRate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, err := rates.Rate(context.Background(), tt.from, tt.to)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Rate: error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Rate: %v", err)
+			}
+			if rate.RatString() != tt.want {
+				t.Errorf("rate is %s, want %s", rate.RatString(), tt.want)
+			}
+		})
+	}
+
+	// callers may change the rate they get without changing the table
+	rate, _ := rates.Rate(context.Background(), "USD", "EUR")
+	rate.SetInt64(7)
+	if again, _ := rates.Rate(context.Background(), "USD", "EUR"); again.RatString() != "1/2" {
+		t.Errorf("rate is %s after a caller changed its copy, want 1/2", again.RatString())
+	}
+}
+
+func TestConvert(t *testing.T) {
+	usd := Currency{Code: "USD", Exponent: 2}
+	eur := Currency{Code: "EUR", Exponent: 2}
+	jpy := Currency{Code: "JPY", Exponent: 0}
+	bhd := Currency{Code: "BHD", Exponent: 3}
+	rate := func(s string) *big.Rat {
+		r, err := ParseRate(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		amount   int64
+		from, to Currency
+		rate     string
+		want     int64
+		wantErr  error
+	}{
+		{name: "exact", amount: 1000, from: usd, to: eur, rate: "0.5", want: 500},
+		{name: "rounds down", amount: 333, from: usd, to: eur, rate: "0.5", want: 166},
+		{name: "rounds down just below a unit", amount: 1, from: usd, to: eur, rate: "0.9999999999", want: 0},
+		{name: "never rounds up", amount: 999, from: usd, to: eur, rate: "1.0010010011", want: 1000},
+		{name: "fewer minor digits", amount: 199, from: usd, to: jpy, rate: "150", want: 298},
+		{name: "more minor digits", amount: 1, from: usd, to: bhd, rate: "0.376", want: 3},
+		{name: "largest amount", amount: math.MaxInt64, from: usd, to: eur, rate: "1", want: math.MaxInt64},
+		{name: "overflow", amount: math.MaxInt64, from: usd, to: eur, rate: "2", wantErr: ErrOutOfRange},
+		{name: "overflow by rescaling", amount: math.MaxInt64 / 2, from: usd, to: bhd, rate: "1", wantErr: ErrOutOfRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Convert(tt.amount, tt.from, tt.to, rate(tt.rate))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Convert: %d, error %v; want %v", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Convert: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Convert(%d) = %d, want %d", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewQuoteRoundsRate(t *testing.T) {
+	usd := Currency{Code: "USD", Exponent: 2}
+	eur := Currency{Code: "EUR", Exponent: 2}
+
+	q, err := NewQuote(usd, eur, big.NewRat(1, 3))
+	if err != nil {
+		t.Fatalf("NewQuote: %v", err)
+	}
+	if got := FormatRate(q.Rate); got != "0.3333333333" {
+		t.Errorf("rate is %s, want 0.3333333333", got)
+	}
+	// the rounded rate is the one applied, not a third
+	if got, err := q.Convert(3000000000000); err != nil || got != 999999999900 {
+		t.Errorf("Convert = %d, %v; want 999999999900", got, err)
+	}
+}
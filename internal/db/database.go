@@ -3,16 +3,28 @@ package db
 import (
 	"context"
 	"log"
-	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lahaehae/crud_project/internal/config"
+	"github.com/lahaehae/crud_project/internal/db/migrations"
 )
 
-func InitDB(dsn string) (*pgxpool.Pool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// InitDB connects to the database and, when cfg.MigrateOnStart is set,
+// applies any pending schema migrations before returning the pool.
+func InitDB(cfg config.DB) (*pgxpool.Pool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
 	defer cancel()
 
-	pool, err := pgxpool.New(ctx, dsn)
+	poolCfg, err := pgxpool.ParseConfig(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	poolCfg.MaxConns = cfg.MaxConns
+	poolCfg.MinConns = cfg.MinConns
+	poolCfg.MaxConnLifetime = cfg.MaxConnLifetime
+	poolCfg.MaxConnIdleTime = cfg.MaxConnIdleTime
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil{
 		return nil, err
 	}
@@ -24,5 +36,20 @@ func InitDB(dsn string) (*pgxpool.Pool, error) {
 	}
 
 	log.Println("Successfully connected to database")
+
+	if cfg.MigrateOnStart {
+		migrator, err := migrations.NewMigrator(pool)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		// migrations may take longer than connecting does
+		applied, err := migrator.Up(context.Background())
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		log.Printf("Applied %d migration(s)", len(applied))
+	}
 	return pool, nil
-} 
\ No newline at end of file
+} 
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_balance_id_idx;
+DROP INDEX IF EXISTS users_name_id_idx;
+DROP INDEX IF EXISTS users_email_lower_key;
@@ -0,0 +1,7 @@
+-- Normalize existing addresses first; duplicates that remain after this
+-- have to be resolved by hand before the unique index can be built.
+UPDATE users SET email = lower(trim(email)) WHERE email <> lower(trim(email));
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (lower(email));
+CREATE INDEX IF NOT EXISTS users_name_id_idx ON users ((COALESCE(name, '')), id);
+CREATE INDEX IF NOT EXISTS users_balance_id_idx ON users (balance, id);
@@ -0,0 +1 @@
+DELETE FROM users WHERE email IN ('alice@mail.ru', 'bobmarley@gmail.com');
@@ -0,0 +1,4 @@
+INSERT INTO users (name, email, balance) VALUES
+('Alice', 'alice@mail.ru', 1000),
+('Bob', 'bobmarley@gmail.com', 2000)
+ON CONFLICT DO NOTHING;
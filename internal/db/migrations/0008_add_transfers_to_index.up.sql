@@ -0,0 +1,2 @@
+-- migrate:no-transaction
+CREATE INDEX CONCURRENTLY IF NOT EXISTS transfers_to_id_created_at_idx ON transfers (to_id, created_at DESC, id DESC);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS transfers_reversal_of_idx;
+ALTER TABLE transfers DROP CONSTRAINT IF EXISTS transfers_reversed_amount_check;
+ALTER TABLE transfers
+    DROP COLUMN IF EXISTS reversed_amount,
+    DROP COLUMN IF EXISTS reversal_of;
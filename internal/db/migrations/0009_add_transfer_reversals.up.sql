@@ -0,0 +1,9 @@
+ALTER TABLE transfers
+    ADD COLUMN IF NOT EXISTS reversal_of BIGINT REFERENCES transfers (id),
+    ADD COLUMN IF NOT EXISTS reversed_amount BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE transfers DROP CONSTRAINT IF EXISTS transfers_reversed_amount_check;
+ALTER TABLE transfers ADD CONSTRAINT transfers_reversed_amount_check
+    CHECK (reversed_amount >= 0 AND reversed_amount <= amount);
+
+CREATE INDEX IF NOT EXISTS transfers_reversal_of_idx ON transfers (reversal_of) WHERE reversal_of IS NOT NULL;
@@ -0,0 +1,56 @@
+-- Every balance change is a ledger transaction whose entries sum to zero.
+-- An entry belongs either to a user or to one of the system accounts that
+-- stand for money entering and leaving the books. users.balance is a cache
+-- of the sum of the user's entries and is only changed together with them.
+CREATE TABLE IF NOT EXISTS ledger_transactions (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR NOT NULL CHECK (kind IN ('opening', 'deposit', 'withdrawal', 'transfer')),
+    transfer_id BIGINT REFERENCES transfers (id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_id BIGINT NOT NULL REFERENCES ledger_transactions (id),
+    user_id INTEGER,
+    system_account VARCHAR CHECK (system_account IN ('opening', 'cash')),
+    amount BIGINT NOT NULL CHECK (amount <> 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK ((user_id IS NULL) <> (system_account IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_transaction_id_idx ON ledger_entries (transaction_id);
+CREATE INDEX IF NOT EXISTS ledger_entries_user_id_idx ON ledger_entries (user_id, id) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS ledger_transactions_transfer_id_idx ON ledger_transactions (transfer_id) WHERE transfer_id IS NOT NULL;
+
+-- Checked at commit, once all entries of a transaction have been written.
+CREATE OR REPLACE FUNCTION ledger_check_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT sum(amount) FROM ledger_entries WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger transaction % is not balanced', NEW.transaction_id
+            USING ERRCODE = 'check_violation', CONSTRAINT = 'ledger_entries_balanced';
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_entries_balanced ON ledger_entries;
+CREATE CONSTRAINT TRIGGER ledger_entries_balanced
+    AFTER INSERT ON ledger_entries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION ledger_check_balanced();
+
+-- Existing balances have no history; book them as opening balances.
+WITH opening AS (
+    INSERT INTO ledger_transactions (kind)
+    SELECT 'opening' FROM users WHERE balance > 0 ORDER BY id
+    RETURNING id
+), numbered_tx AS (
+    SELECT id, row_number() OVER (ORDER BY id) AS n FROM opening
+), numbered_users AS (
+    SELECT id, balance, row_number() OVER (ORDER BY id) AS n FROM users WHERE balance > 0
+)
+INSERT INTO ledger_entries (transaction_id, user_id, system_account, amount)
+SELECT t.id, u.id, NULL, u.balance FROM numbered_tx t JOIN numbered_users u USING (n)
+UNION ALL
+SELECT t.id, NULL, 'opening', -u.balance FROM numbered_tx t JOIN numbered_users u USING (n);
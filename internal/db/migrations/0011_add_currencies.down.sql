@@ -0,0 +1,25 @@
+CREATE OR REPLACE FUNCTION ledger_check_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT sum(amount) FROM ledger_entries WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger transaction % is not balanced', NEW.transaction_id
+            USING ERRCODE = 'check_violation', CONSTRAINT = 'ledger_entries_balanced';
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_system_account_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_system_account_check
+    CHECK (system_account IN ('opening', 'cash'));
+ALTER TABLE ledger_entries DROP COLUMN IF EXISTS currency;
+
+ALTER TABLE transfers DROP CONSTRAINT IF EXISTS transfers_conversion_check;
+ALTER TABLE transfers
+    DROP COLUMN IF EXISTS rate,
+    DROP COLUMN IF EXISTS to_currency,
+    DROP COLUMN IF EXISTS to_amount,
+    DROP COLUMN IF EXISTS currency;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS currency_exponent,
+    DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,43 @@
+-- Accounts hold a single ISO 4217 currency; balances and amounts are in its
+-- minor unit, which has currency_exponent decimal digits. Existing accounts
+-- and transfers were all in US dollars.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD',
+    ADD COLUMN IF NOT EXISTS currency_exponent SMALLINT NOT NULL DEFAULT 2;
+
+-- amount is debited in currency. A transfer between currencies credits
+-- to_amount of to_currency, converted at rate units of to_currency per unit
+-- of currency.
+ALTER TABLE transfers
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD',
+    ADD COLUMN IF NOT EXISTS to_amount BIGINT,
+    ADD COLUMN IF NOT EXISTS to_currency CHAR(3),
+    ADD COLUMN IF NOT EXISTS rate NUMERIC;
+
+ALTER TABLE transfers DROP CONSTRAINT IF EXISTS transfers_conversion_check;
+ALTER TABLE transfers ADD CONSTRAINT transfers_conversion_check
+    CHECK (
+        (to_amount IS NULL AND to_currency IS NULL AND rate IS NULL)
+        OR (to_amount > 0 AND to_currency <> currency AND rate > 0)
+    );
+
+-- System accounts hold a balance per currency, and the fx account takes the
+-- two sides of every conversion.
+ALTER TABLE ledger_entries ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_system_account_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_system_account_check
+    CHECK (system_account IN ('opening', 'cash', 'fx'));
+
+-- A transaction must balance in every currency it touches.
+CREATE OR REPLACE FUNCTION ledger_check_balanced() RETURNS trigger AS $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM ledger_entries WHERE transaction_id = NEW.transaction_id
+        GROUP BY currency HAVING sum(amount) <> 0
+    ) THEN
+        RAISE EXCEPTION 'ledger transaction % is not balanced', NEW.transaction_id
+            USING ERRCODE = 'check_violation', CONSTRAINT = 'ledger_entries_balanced';
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
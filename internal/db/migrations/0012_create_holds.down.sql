@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS holds;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_held_check;
+ALTER TABLE users DROP COLUMN IF EXISTS held;
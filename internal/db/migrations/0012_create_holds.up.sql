@@ -0,0 +1,27 @@
+-- A hold reserves part of a user's balance for a later transfer to to_id.
+-- It is not a ledger movement: the reserved total is kept in users.held and
+-- only lowers the available balance, balance - held, until the hold is
+-- captured into a transfer, voided or expires.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS held BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_held_check;
+ALTER TABLE users ADD CONSTRAINT users_held_check CHECK (held >= 0 AND held <= balance);
+
+CREATE TABLE IF NOT EXISTS holds (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    to_id INTEGER NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency CHAR(3) NOT NULL,
+    status VARCHAR NOT NULL CHECK (status IN ('active', 'captured', 'voided', 'expired')),
+    captured_amount BIGINT NOT NULL DEFAULT 0,
+    transfer_id BIGINT REFERENCES transfers (id),
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK (captured_amount >= 0 AND captured_amount <= amount),
+    CHECK ((status = 'captured') = (transfer_id IS NOT NULL))
+);
+
+-- The sweeper only ever looks at active holds.
+CREATE INDEX IF NOT EXISTS holds_active_expires_at_idx ON holds (expires_at) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS holds_user_id_idx ON holds (user_id, id);
@@ -0,0 +1,47 @@
+-- A scheduled transfer runs once at run_at, or repeatedly by a cron
+-- expression or a fixed repeat_interval counted from start_at, until end_at
+-- or max_runs is reached. next_run_at is the next occurrence and is only set
+-- while the schedule is active; retry_at is set while a failed occurrence
+-- waits to be retried.
+CREATE TABLE IF NOT EXISTS scheduled_transfers (
+    id BIGSERIAL PRIMARY KEY,
+    from_id INTEGER NOT NULL,
+    to_id INTEGER NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency CHAR(3) NOT NULL,
+    run_at TIMESTAMPTZ,
+    cron VARCHAR,
+    repeat_interval VARCHAR,
+    start_at TIMESTAMPTZ NOT NULL,
+    end_at TIMESTAMPTZ,
+    max_runs INTEGER CHECK (max_runs > 0),
+    run_count INTEGER NOT NULL DEFAULT 0,
+    attempt INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR NOT NULL CHECK (status IN ('active', 'completed', 'cancelled')),
+    next_run_at TIMESTAMPTZ,
+    retry_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK (num_nonnulls(run_at, cron, repeat_interval) = 1),
+    CHECK ((status = 'active') = (next_run_at IS NOT NULL))
+);
+
+-- The scheduler only ever looks for active schedules that are due.
+CREATE INDEX IF NOT EXISTS scheduled_transfers_due_idx
+    ON scheduled_transfers ((COALESCE(retry_at, next_run_at)), id) WHERE status = 'active';
+
+-- One row per attempt of an occurrence, whether it moved money or not.
+CREATE TABLE IF NOT EXISTS scheduled_transfer_runs (
+    id BIGSERIAL PRIMARY KEY,
+    schedule_id BIGINT NOT NULL REFERENCES scheduled_transfers (id),
+    scheduled_for TIMESTAMPTZ NOT NULL,
+    attempt INTEGER NOT NULL,
+    status VARCHAR NOT NULL CHECK (status IN ('succeeded', 'failed')),
+    transfer_id BIGINT REFERENCES transfers (id),
+    error TEXT,
+    retry_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK ((status = 'succeeded') = (transfer_id IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_transfer_runs_schedule_id_idx ON scheduled_transfer_runs (schedule_id, id);
@@ -0,0 +1,10 @@
+-- Per-user overrides of the global transfer limits. A NULL column inherits
+-- the global limit, zero lifts it for the user.
+CREATE TABLE IF NOT EXISTS user_limits (
+    user_id INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    max_amount BIGINT CHECK (max_amount >= 0),
+    daily_amount BIGINT CHECK (daily_amount >= 0),
+    monthly_amount BIGINT CHECK (monthly_amount >= 0),
+    hourly_count BIGINT CHECK (hourly_count >= 0),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,5 @@
+ALTER TABLE holds DROP CONSTRAINT IF EXISTS holds_to_id_fkey;
+ALTER TABLE holds DROP CONSTRAINT IF EXISTS holds_user_id_fkey;
+ALTER TABLE transfers DROP CONSTRAINT IF EXISTS transfers_to_id_fkey;
+ALTER TABLE transfers DROP CONSTRAINT IF EXISTS transfers_from_id_fkey;
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_user_id_fkey;
@@ -0,0 +1,23 @@
+-- Ledger entries, transfers and holds must keep the account they belong to:
+-- deleting a user they reference would take its money out of the books.
+-- Only users that never moved money can be deleted.
+--
+-- The constraints are added NOT VALID, so they hold for new rows at once
+-- without failing on rows written before them; 0016 validates the rest.
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_user_id_fkey;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users (id) NOT VALID;
+
+ALTER TABLE transfers DROP CONSTRAINT IF EXISTS transfers_from_id_fkey;
+ALTER TABLE transfers ADD CONSTRAINT transfers_from_id_fkey
+    FOREIGN KEY (from_id) REFERENCES users (id) NOT VALID;
+ALTER TABLE transfers DROP CONSTRAINT IF EXISTS transfers_to_id_fkey;
+ALTER TABLE transfers ADD CONSTRAINT transfers_to_id_fkey
+    FOREIGN KEY (to_id) REFERENCES users (id) NOT VALID;
+
+ALTER TABLE holds DROP CONSTRAINT IF EXISTS holds_user_id_fkey;
+ALTER TABLE holds ADD CONSTRAINT holds_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users (id) NOT VALID;
+ALTER TABLE holds DROP CONSTRAINT IF EXISTS holds_to_id_fkey;
+ALTER TABLE holds ADD CONSTRAINT holds_to_id_fkey
+    FOREIGN KEY (to_id) REFERENCES users (id) NOT VALID;
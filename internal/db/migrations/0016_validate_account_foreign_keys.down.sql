@@ -0,0 +1,2 @@
+-- A validated constraint cannot be marked NOT VALID again; reverting 0015
+-- drops the constraints.
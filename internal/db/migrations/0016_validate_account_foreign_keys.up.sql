@@ -0,0 +1,41 @@
+-- Rows written before 0015 may reference users deleted since. They carry
+-- money, so they are not removed here: the block below reports them and
+-- stops the migration, and they have to be resolved by hand, typically by
+-- restoring the missing users, before it is run again.
+DO $$
+DECLARE
+    orphans text;
+BEGIN
+    SELECT string_agg(format('%s.%s = %s (%s rows)', tbl, col, id, n), ', ')
+    INTO orphans
+    FROM (
+        SELECT 'ledger_entries' AS tbl, 'user_id' AS col, user_id AS id, count(*) AS n
+        FROM ledger_entries
+        WHERE user_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = user_id)
+        GROUP BY user_id
+        UNION ALL
+        SELECT 'transfers', 'from_id', from_id, count(*)
+        FROM transfers WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id = from_id) GROUP BY from_id
+        UNION ALL
+        SELECT 'transfers', 'to_id', to_id, count(*)
+        FROM transfers WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id = to_id) GROUP BY to_id
+        UNION ALL
+        SELECT 'holds', 'user_id', user_id, count(*)
+        FROM holds WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id = user_id) GROUP BY user_id
+        UNION ALL
+        SELECT 'holds', 'to_id', to_id, count(*)
+        FROM holds WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id = to_id) GROUP BY to_id
+    ) missing;
+
+    IF orphans IS NOT NULL THEN
+        RAISE EXCEPTION 'rows reference missing users: %', orphans
+            USING HINT = 'restore or remove these rows by hand, then migrate again';
+    END IF;
+END
+$$;
+
+ALTER TABLE ledger_entries VALIDATE CONSTRAINT ledger_entries_user_id_fkey;
+ALTER TABLE transfers VALIDATE CONSTRAINT transfers_from_id_fkey;
+ALTER TABLE transfers VALIDATE CONSTRAINT transfers_to_id_fkey;
+ALTER TABLE holds VALIDATE CONSTRAINT holds_user_id_fkey;
+ALTER TABLE holds VALIDATE CONSTRAINT holds_to_id_fkey;
@@ -0,0 +1,3 @@
+-- Response headers other than Content-Type that are replayed with a stored
+-- response, such as ETag and Location, as a JSON object.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS response_headers JSONB;
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lahaehae/crud_project/internal/models"
+)
+
+// AdminAuth admits only requests carrying "Authorization: Bearer <token>".
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			c.Error(newStatusError(http.StatusUnauthorized, "A valid admin bearer token is required."))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Лимиты переводов пользователя: переопределения, действующие лимиты и использование
+func (h *UserHandler) GetLimits(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(invalidField("id", "must be an integer"))
+		return
+	}
+
+	limits, err := h.service.GetLimits(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, limits)
+}
+
+// Замена переопределений лимитов пользователя; null — глобальный лимит, 0 — без лимита
+func (h *UserHandler) SetLimits(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(invalidField("id", "must be an integer"))
+		return
+	}
+
+	var req models.Limits
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindingError(err))
+		return
+	}
+
+	limits, err := h.service.SetLimits(c.Request.Context(), id, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, limits)
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lahaehae/crud_project/internal/models"
+)
+
+// BatchTransferRequest is the body of POST /transfers/batch. Mode is atomic
+// or best_effort; each transfer has the fields of POST /transfer.
+type BatchTransferRequest struct {
+	Mode      string            `json:"mode"`
+	Transfers []TransferRequest `json:"transfers"`
+}
+
+// Outcomes of a single transfer of a batch.
+const (
+	BatchItemSucceeded = "succeeded"
+	BatchItemFailed    = "failed"
+)
+
+// BatchTransferResult is the outcome of the transfer at Index of the request:
+// the transfer made, or the problem that prevented it.
+type BatchTransferResult struct {
+	Index    int              `json:"index"`
+	Status   string           `json:"status"`
+	Transfer *models.Transfer `json:"transfer,omitempty"`
+	Error    *Problem         `json:"error,omitempty"`
+}
+
+type BatchTransferResponse struct {
+	Mode      string                `json:"mode"`
+	Succeeded int                   `json:"succeeded"`
+	Failed    int                   `json:"failed"`
+	Results   []BatchTransferResult `json:"results"`
+}
+
+// Пакетный перевод средств: все или ничего (atomic) либо по возможности (best_effort)
+func (h *UserHandler) TransferBatch(c *gin.Context) {
+	var req BatchTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindingError(err))
+		return
+	}
+
+	orders := make([]models.TransferOrder, len(req.Transfers))
+	for i, t := range req.Transfers {
+		orders[i] = models.TransferOrder{FromId: t.FromID, ToId: t.ToID, Amount: t.Balance, Currency: t.Currency}
+	}
+
+	results, err := h.service.TransferBatch(c.Request.Context(), req.Mode, orders)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	resp := BatchTransferResponse{Mode: req.Mode, Results: make([]BatchTransferResult, len(results))}
+	for i, r := range results {
+		item := BatchTransferResult{Index: i, Status: BatchItemSucceeded, Transfer: r.Transfer}
+		if r.Err != nil {
+			p := problemFor(r.Err)
+			if p.Status == http.StatusInternalServerError {
+				log.Printf("%s %s: transfer %d: %v", c.Request.Method, c.Request.URL.Path, i, r.Err)
+			}
+			p.Title = http.StatusText(p.Status)
+			item.Status, item.Error = BatchItemFailed, &p
+			resp.Failed++
+		} else {
+			resp.Succeeded++
+		}
+		resp.Results[i] = item
+	}
+
+	// 207 tells a best-effort caller to look at each result
+	status := http.StatusCreated
+	if resp.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, resp)
+}
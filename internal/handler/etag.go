@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lahaehae/crud_project/internal/repository"
+)
+
+// A user's ETag is its row version as a quoted string, e.g. "3".
+
+func setETag(c *gin.Context, version int64) {
+	c.Header("ETag", strconv.Quote(strconv.FormatInt(version, 10)))
+}
+
+// ifMatchVersion extracts the version the client expects from If-Match.
+// Zero means the write is unconditional. The header may list several tags,
+// any of which may match; the current version of user id is then looked up
+// and returned if it is among them, and the write stays conditional on it.
+func (h *UserHandler) ifMatchVersion(c *gin.Context, id int64) (int64, error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		if h.requireIfMatch {
+			return 0, &statusError{
+				status:      http.StatusPreconditionRequired,
+				problemType: ProblemTypePreconditionRequired,
+				detail:      "If-Match header is required.",
+			}
+		}
+		return 0, nil
+	}
+
+	var versions []int64
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return 0, nil
+		}
+		// tags we never issued cannot match the current representation, and
+		// If-Match compares strongly, so neither can a weak one
+		if v, ok := parseETag(tag); ok {
+			versions = append(versions, v)
+		}
+	}
+	switch len(versions) {
+	case 0:
+		return 0, repository.ErrVersionMismatch
+	case 1:
+		return versions[0], nil
+	}
+
+	user, err := h.service.GetUser(c.Request.Context(), id)
+	if err != nil {
+		return 0, err
+	}
+	if !slices.Contains(versions, user.Version) {
+		return 0, repository.ErrVersionMismatch
+	}
+	return user.Version, nil
+}
+
+// parseETag returns the version a strong user ETag stands for.
+func parseETag(tag string) (int64, bool) {
+	if strings.HasPrefix(tag, "W/") {
+		return 0, false
+	}
+	unquoted, err := strconv.Unquote(tag)
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, false
+	}
+	return v, true
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lahaehae/crud_project/internal/currency"
+	"github.com/lahaehae/crud_project/internal/repository"
+	"github.com/lahaehae/crud_project/internal/service"
+)
+
+func TestIfMatchList(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := repository.NewMemoryUserRepository()
+	rates, err := currency.NewStaticRates(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewUserService(repo, repo, rates, service.Config{})
+	user, err := svc.CreateUser(context.Background(), "Ann", "ann@example.com", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := strconv.Quote(strconv.FormatInt(user.Version, 10))
+
+	r := gin.New()
+	r.Use(ErrorHandler())
+	r.PUT("/users/:id", NewUserHandler(svc, true).UpdateUser)
+	path := "/users/" + strconv.FormatInt(user.Id, 10)
+
+	tests := []struct {
+		name    string
+		ifMatch string
+		want    int
+	}{
+		// If-Match compares strongly: a weak tag never matches, even one
+		// naming the current version
+		{"weak current", "W/" + current, http.StatusPreconditionFailed},
+		{"weak current in list", `"99", W/` + current, http.StatusPreconditionFailed},
+		{"stale list", `"98", "99"`, http.StatusPreconditionFailed},
+		{"list with current", `"99", ` + current, http.StatusOK},
+		{"list with wildcard", `"99", *`, http.StatusOK},
+		{"unparsable tags", `"x", W/"y"`, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(`{"name":"Ann","email":"ann@example.com"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("If-Match", tt.ifMatch)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("If-Match %s: status %d, want %d; body %s", tt.ifMatch, w.Code, tt.want, w.Body)
+			}
+			if w.Code == http.StatusOK {
+				current = w.Header().Get("ETag")
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lahaehae/crud_project/internal/health"
+)
+
+type HealthHandler struct {
+	registry *health.Registry
+}
+
+func NewHealthHandler(registry *health.Registry) *HealthHandler {
+	return &HealthHandler{registry: registry}
+}
+
+// Liveness reports that the process is able to serve requests at all. It
+// checks no dependencies: restarting the pod would not fix them.
+func (h *HealthHandler) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusUp})
+}
+
+// Readiness reports whether the instance should receive traffic: every check
+// passes and it is not shutting down.
+func (h *HealthHandler) Readiness(c *gin.Context) {
+	if h.registry.Draining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": health.StatusDown, "draining": true})
+		return
+	}
+
+	report := h.registry.Run(c.Request.Context())
+	if !report.Healthy() {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusUp})
+}
+
+// Report returns the result and latency of every check.
+func (h *HealthHandler) Report(c *gin.Context) {
+	report := h.registry.Run(c.Request.Context())
+	status := http.StatusOK
+	if !report.Healthy() || report.Draining {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HoldRequest is the body of POST /users/:id/holds. Amount is in minor
+// units of Currency, which must be the holder's.
+type HoldRequest struct {
+	ToID     int64  `json:"to_id"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// CaptureRequest is the optional body of a capture; a missing or zero
+// amount captures the whole hold.
+type CaptureRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+// Резервирование средств пользователя
+func (h *UserHandler) CreateHold(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(invalidField("id", "must be an integer"))
+		return
+	}
+
+	var req HoldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindingError(err))
+		return
+	}
+
+	hold, err := h.service.CreateHold(c.Request.Context(), id, req.ToID, req.Amount, req.Currency)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusCreated, hold)
+}
+
+// Резерв по id
+func (h *UserHandler) GetHold(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(invalidField("id", "must be an integer"))
+		return
+	}
+
+	hold, err := h.service.GetHold(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, hold)
+}
+
+// Списание резерва переводом получателю, полное или частичное
+func (h *UserHandler) CaptureHold(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(invalidField("id", "must be an integer"))
+		return
+	}
+
+	var req CaptureRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(bindingError(err))
+			return
+		}
+	}
+
+	transfer, err := h.service.CaptureHold(c.Request.Context(), id, req.Amount)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusCreated, transfer)
+}
+
+// Отмена резерва
+func (h *UserHandler) VoidHold(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(invalidField("id", "must be an integer"))
+		return
+	}
+
+	hold, err := h.service.VoidHold(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, hold)
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lahaehae/crud_project/internal/repository"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+// replayedHeaders are the response headers, besides Content-Type, that are
+// stored with an idempotency key and sent again with the replayed response.
+var replayedHeaders = []string{"ETag", "Location"}
+
+// responseRecorder copies everything written to the client so that the
+// response can be stored alongside its idempotency key.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency makes a handler safe to retry. The first response for a given
+// Idempotency-Key is stored and replayed for every repeat of the same request;
+// reusing the key for a different request is rejected with 422. Requests
+// without the header are passed through untouched.
+func Idempotency(repo repository.IdempotencyStore, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.Error(invalidField(IdempotencyKeyHeader, "must be at most "+strconv.Itoa(maxIdempotencyKeyLength)+" characters"))
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Error(invalidField("body", "could not be read"))
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := requestFingerprint(c.Request.Method, c.Request.URL.Path, body)
+
+		record, reserved, err := repo.Reserve(c.Request.Context(), key, fingerprint, ttl)
+		if err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		if !reserved {
+			switch {
+			case record.Fingerprint != fingerprint:
+				c.Error(&statusError{
+					status:      http.StatusUnprocessableEntity,
+					problemType: ProblemTypeIdempotencyKeyReused,
+					detail:      "Idempotency-Key was already used with a different request.",
+				})
+				c.Abort()
+			case record.StatusCode == 0:
+				c.Error(&statusError{
+					status:      http.StatusConflict,
+					problemType: ProblemTypeIdempotencyKeyInFlight,
+					detail:      "A request with this Idempotency-Key is still being processed.",
+				})
+				c.Abort()
+			default:
+				for name, value := range record.Headers {
+					c.Header(name, value)
+				}
+				c.Header(IdempotentReplayedHeader, "true")
+				c.Data(record.StatusCode, record.ContentType, record.ResponseBody)
+				c.Abort()
+			}
+			return
+		}
+
+		// The outcome must be persisted even if the client has already gone away.
+		ctx := context.WithoutCancel(c.Request.Context())
+
+		// Unless a response is stored below, the key is released again, also
+		// when the handler panics, so that the request can be retried.
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := repo.Release(ctx, key); err != nil {
+				log.Printf("failed to release idempotency key %q: %v", key, err)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+		// errors must be rendered now so that the stored response includes them
+		writePendingError(c)
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		headers := make(map[string]string)
+		for _, name := range replayedHeaders {
+			if value := recorder.Header().Get(name); value != "" {
+				headers[name] = value
+			}
+		}
+		if err := repo.Complete(ctx, key, status, recorder.Header().Get("Content-Type"), headers, recorder.body.Bytes()); err != nil {
+			log.Printf("failed to store response for idempotency key %q: %v", key, err)
+			return
+		}
+		completed = true
+	}
+}
+
+func requestFingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(path))
+	h.Write([]byte{'\n'})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lahaehae/crud_project/internal/repository"
+)
+
+func TestIdempotencyReleasesKeyAfterPanic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := repository.NewMemoryIdempotencyRepository()
+
+	panics := true
+	r := gin.New()
+	r.Use(Recovery(), ErrorHandler())
+	r.POST("/things", Idempotency(store, time.Hour), func(c *gin.Context) {
+		if panics {
+			panic("boom")
+		}
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/things", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(); w.Code != http.StatusInternalServerError {
+		t.Fatalf("panicking request: status %d, want 500", w.Code)
+	}
+	panics = false
+	if w := send(); w.Code != http.StatusCreated {
+		t.Fatalf("retry after panic: status %d, want 201; body %s", w.Code, w.Body)
+	}
+	w := send()
+	if w.Code != http.StatusCreated || w.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Fatalf("repeat: status %d, replayed %q; want a replayed 201", w.Code, w.Header().Get(IdempotentReplayedHeader))
+	}
+}
+
+func TestIdempotencyReplaysHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := repository.NewMemoryIdempotencyRepository()
+
+	r := gin.New()
+	r.Use(ErrorHandler())
+	r.POST("/things", Idempotency(store, time.Hour), func(c *gin.Context) {
+		c.Header("ETag", `"1"`)
+		c.Header("Location", "/things/1")
+		c.Header("X-Request-Id", "not replayed")
+		c.JSON(http.StatusCreated, gin.H{"id": 1})
+	})
+	r.POST("/broken", Idempotency(store, time.Hour), func(c *gin.Context) {
+		c.Error(invalidField("name", "is required"))
+	})
+
+	tests := []struct {
+		path   string
+		status int
+		want   map[string]string
+	}{
+		{"/things", http.StatusCreated, map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+			"ETag":         `"1"`,
+			"Location":     "/things/1",
+			"X-Request-Id": "",
+		}},
+		{"/broken", http.StatusBadRequest, map[string]string{
+			"Content-Type": "application/problem+json",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			send := func() *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{}`))
+				req.Header.Set(IdempotencyKeyHeader, "key"+tt.path)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				return w
+			}
+
+			first := send()
+			w := send()
+			if w.Code != tt.status || w.Header().Get(IdempotentReplayedHeader) != "true" {
+				t.Fatalf("repeat: status %d, replayed %q; want a replayed %d", w.Code, w.Header().Get(IdempotentReplayedHeader), tt.status)
+			}
+			if w.Body.String() != first.Body.String() {
+				t.Errorf("replayed body %s, want %s", w.Body, first.Body)
+			}
+			for name, want := range tt.want {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("replayed %s is %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/lahaehae/crud_project/internal/currency"
+	"github.com/lahaehae/crud_project/internal/repository"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem types. They are relative URI references, resolved against the API
+// base URL, and never change once published.
+const (
+	ProblemTypeValidation             = "/problems/validation-error"
+	ProblemTypeNotFound               = "/problems/not-found"
+	ProblemTypeAccountNotFound        = "/problems/account-not-found"
+	ProblemTypeConflict               = "/problems/conflict"
+	ProblemTypeVersionMismatch        = "/problems/version-mismatch"
+	ProblemTypePreconditionRequired   = "/problems/precondition-required"
+	ProblemTypeInsufficientFunds      = "/problems/insufficient-funds"
+	ProblemTypeAlreadyReversed        = "/problems/transfer-already-reversed"
+	ProblemTypeNotReversible          = "/problems/transfer-not-reversible"
+	ProblemTypeReversalExceeded       = "/problems/reversal-exceeded"
+	ProblemTypeCurrencyMismatch       = "/problems/currency-mismatch"
+	ProblemTypeHoldNotActive          = "/problems/hold-not-active"
+	ProblemTypeScheduleNotActive      = "/problems/schedule-not-active"
+	ProblemTypeAccountInUse           = "/problems/account-in-use"
+	ProblemTypeRateUnavailable        = "/problems/exchange-rate-unavailable"
+	ProblemTypeLimitExceeded          = "/problems/limit-exceeded"
+	ProblemTypeIdempotencyKeyReused   = "/problems/idempotency-key-reused"
+	ProblemTypeIdempotencyKeyInFlight = "/problems/idempotency-key-in-flight"
+	ProblemTypeInternal               = "/problems/internal-error"
+)
+
+// Problem is an RFC 7807 problem details object together with the extension
+// members some problem types carry.
+type Problem struct {
+	Type       string                  `json:"type"`
+	Title      string                  `json:"title"`
+	Status     int                     `json:"status"`
+	Detail     string                  `json:"detail,omitempty"`
+	Instance   string                  `json:"instance,omitempty"`
+	TraceID    string                  `json:"trace_id,omitempty"`
+	Errors     []repository.FieldError `json:"errors,omitempty"`
+	AccountID  int64                   `json:"account_id,omitempty"`
+	Balance    *int64                  `json:"balance,omitempty"`
+	Amount     int64                   `json:"amount,omitempty"`
+	Reversible *int64                  `json:"reversible,omitempty"`
+	Currency   string                  `json:"currency,omitempty"`
+	Limit      string                  `json:"limit,omitempty"`
+	Allowed    *int64                  `json:"allowed,omitempty"`
+	Used       *int64                  `json:"used,omitempty"`
+	Index      *int                    `json:"index,omitempty"`
+}
+
+// statusError is a transport-level failure that is reported as is, e.g. a
+// missing header or an unsupported media type.
+type statusError struct {
+	status      int
+	problemType string
+	detail      string
+}
+
+func (e *statusError) Error() string {
+	return e.detail
+}
+
+func newStatusError(status int, detail string) *statusError {
+	return &statusError{status: status, problemType: "about:blank", detail: detail}
+}
+
+// invalidField reports a single malformed request parameter.
+func invalidField(field, message string) error {
+	return &repository.ValidationError{Fields: []repository.FieldError{{Field: field, Message: message}}}
+}
+
+// bindingError converts a request body decoding failure into a validation
+// error that names the offending fields where possible.
+func bindingError(err error) error {
+	var (
+		typeErr   *json.UnmarshalTypeError
+		syntaxErr *json.SyntaxError
+		fieldErrs validator.ValidationErrors
+	)
+	switch {
+	case errors.Is(err, io.EOF):
+		return invalidField("body", "must not be empty")
+	case errors.As(err, &typeErr):
+		return invalidField(typeErr.Field, "must be of type "+typeErr.Type.String())
+	case errors.As(err, &syntaxErr):
+		return invalidField("body", "must be valid JSON")
+	case errors.As(err, &fieldErrs):
+		verr := &repository.ValidationError{}
+		for _, fe := range fieldErrs {
+			verr.Fields = append(verr.Fields, repository.FieldError{
+				Field:   fe.Field(),
+				Message: "failed on the '" + fe.Tag() + "' rule",
+			})
+		}
+		return verr
+	default:
+		return invalidField("body", "could not be decoded")
+	}
+}
+
+// problemFor describes err as a problem. Details of unexpected errors are
+// never exposed to the client. The account, reversal, currency and
+// account-in-use problems fill AccountID, Balance, Amount, Reversible and
+// Currency; limit-exceeded fills Limit, Allowed and Used; Index names the
+// failed transfer of a batch.
+func problemFor(err error) Problem {
+	var (
+		statusErr   *statusError
+		validErr    *repository.ValidationError
+		conflictErr *repository.ConflictError
+		accountErr  *repository.AccountNotFoundError
+		fundsErr    *repository.InsufficientFundsError
+		reversalErr *repository.ReversalExceededError
+		currencyErr *repository.CurrencyMismatchError
+		holdErr     *repository.HoldNotActiveError
+		inUseErr    *repository.AccountInUseError
+		itemErr     *repository.BatchItemError
+		limitErr    *repository.LimitExceededError
+	)
+	if errors.As(err, &itemErr) {
+		p := problemFor(itemErr.Err)
+		p.Detail = fmt.Sprintf("Transfer %d of the batch failed: %s", itemErr.Index, p.Detail)
+		p.Index = &itemErr.Index
+		return p
+	}
+	switch {
+	case errors.As(err, &statusErr):
+		return Problem{Type: statusErr.problemType, Status: statusErr.status, Detail: statusErr.detail}
+	case errors.As(err, &validErr):
+		return Problem{
+			Type:   ProblemTypeValidation,
+			Status: http.StatusBadRequest,
+			Detail: "The request contains invalid fields.",
+			Errors: validErr.Fields,
+		}
+	case errors.Is(err, repository.ErrValidation):
+		return Problem{Type: ProblemTypeValidation, Status: http.StatusBadRequest, Detail: "The request violates a data constraint."}
+	case errors.As(err, &accountErr):
+		return Problem{
+			Type:      ProblemTypeAccountNotFound,
+			Status:    http.StatusNotFound,
+			Detail:    fmt.Sprintf("Account %d does not exist.", accountErr.AccountID),
+			AccountID: accountErr.AccountID,
+		}
+	case errors.Is(err, repository.ErrNotFound):
+		return Problem{Type: ProblemTypeNotFound, Status: http.StatusNotFound, Detail: "The requested resource does not exist."}
+	case errors.Is(err, repository.ErrAlreadyReversed):
+		return Problem{Type: ProblemTypeAlreadyReversed, Status: http.StatusConflict, Detail: "The transfer has already been reversed in full."}
+	case errors.Is(err, repository.ErrNotReversible):
+		return Problem{Type: ProblemTypeNotReversible, Status: http.StatusConflict, Detail: "The transfer is a reversal and cannot be reversed."}
+	case errors.As(err, &holdErr):
+		return Problem{Type: ProblemTypeHoldNotActive, Status: http.StatusConflict, Detail: fmt.Sprintf("Hold %d is %s.", holdErr.HoldID, holdErr.Status)}
+	case errors.Is(err, repository.ErrScheduleNotActive):
+		return Problem{Type: ProblemTypeScheduleNotActive, Status: http.StatusConflict, Detail: "The scheduled transfer has already completed or been cancelled."}
+	case errors.As(err, &inUseErr):
+		p := Problem{
+			Type:      ProblemTypeAccountInUse,
+			Status:    http.StatusConflict,
+			Detail:    fmt.Sprintf("Account %d has history in the books and cannot be deleted.", inUseErr.AccountID),
+			AccountID: inUseErr.AccountID,
+		}
+		if inUseErr.Balance != 0 || inUseErr.Held != 0 {
+			p.Detail = fmt.Sprintf("Account %d still has a balance of %d, %d of it held; empty it before deleting.", inUseErr.AccountID, inUseErr.Balance, inUseErr.Held)
+			p.Balance = &inUseErr.Balance
+		}
+		return p
+	case errors.As(err, &reversalErr):
+		return Problem{
+			Type:       ProblemTypeReversalExceeded,
+			Status:     http.StatusUnprocessableEntity,
+			Detail:     fmt.Sprintf("Only %d of transfer %d can still be reversed.", reversalErr.Reversible, reversalErr.TransferID),
+			Amount:     reversalErr.Amount,
+			Reversible: &reversalErr.Reversible,
+		}
+	case errors.As(err, &currencyErr):
+		return Problem{
+			Type:      ProblemTypeCurrencyMismatch,
+			Status:    http.StatusUnprocessableEntity,
+			Detail:    fmt.Sprintf("Account %d is held in %s, not %s.", currencyErr.AccountID, currencyErr.Currency, currencyErr.Expected),
+			AccountID: currencyErr.AccountID,
+			Currency:  currencyErr.Currency,
+		}
+	case errors.As(err, &limitErr):
+		return Problem{
+			Type:      ProblemTypeLimitExceeded,
+			Status:    http.StatusUnprocessableEntity,
+			Detail:    fmt.Sprintf("The transfer would exceed the %s limit of account %d: %d allowed, %d used, %d requested.", limitErr.Limit, limitErr.AccountID, limitErr.Allowed, limitErr.Used, limitErr.Amount),
+			AccountID: limitErr.AccountID,
+			Amount:    limitErr.Amount,
+			Limit:     limitErr.Limit,
+			Allowed:   &limitErr.Allowed,
+			Used:      &limitErr.Used,
+		}
+	case errors.Is(err, currency.ErrNoRate):
+		return Problem{Type: ProblemTypeRateUnavailable, Status: http.StatusUnprocessableEntity, Detail: "No exchange rate is available between the currencies of the accounts."}
+	case errors.Is(err, repository.ErrVersionMismatch):
+		return Problem{Type: ProblemTypeVersionMismatch, Status: http.StatusPreconditionFailed, Detail: "If-Match does not match the current version."}
+	case errors.As(err, &conflictErr) && conflictErr.Field != "":
+		return Problem{
+			Type:   ProblemTypeConflict,
+			Status: http.StatusConflict,
+			Detail: fmt.Sprintf("A resource with this %s already exists.", conflictErr.Field),
+			Errors: []repository.FieldError{{Field: conflictErr.Field, Message: "already exists"}},
+		}
+	case errors.Is(err, repository.ErrConflict):
+		return Problem{Type: ProblemTypeConflict, Status: http.StatusConflict, Detail: "The request conflicts with the current state of the resource."}
+	case errors.As(err, &fundsErr):
+		return Problem{
+			Type:      ProblemTypeInsufficientFunds,
+			Status:    http.StatusUnprocessableEntity,
+			Detail:    fmt.Sprintf("Account %d has an available balance of %d, which does not cover %d.", fundsErr.AccountID, fundsErr.Balance, fundsErr.Amount),
+			AccountID: fundsErr.AccountID,
+			Balance:   &fundsErr.Balance,
+			Amount:    fundsErr.Amount,
+		}
+	case errors.Is(err, repository.ErrInsufficientFunds):
+		return Problem{Type: ProblemTypeInsufficientFunds, Status: http.StatusUnprocessableEntity, Detail: "The account balance is too low for this operation."}
+	default:
+		return Problem{Type: ProblemTypeInternal, Status: http.StatusInternalServerError, Detail: "An unexpected error occurred."}
+	}
+}
+
+// writeProblem renders err as application/problem+json.
+func writeProblem(c *gin.Context, err error) {
+	p := problemFor(err)
+	if p.Status == http.StatusInternalServerError {
+		log.Printf("%s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+	}
+	p.Title = http.StatusText(p.Status)
+	p.Instance = c.Request.URL.Path
+	if sc := trace.SpanContextFromContext(c.Request.Context()); sc.HasTraceID() {
+		p.TraceID = sc.TraceID().String()
+	}
+
+	body, merr := json.Marshal(p)
+	if merr != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Data(p.Status, ProblemContentType, body)
+}
+
+// writePendingError renders the last error attached to the context, if the
+// handler has not written a response of its own.
+func writePendingError(c *gin.Context) {
+	if len(c.Errors) == 0 || c.Writer.Written() {
+		return
+	}
+	writeProblem(c, c.Errors.Last().Err)
+}
+
+// ErrorHandler renders errors that handlers attach with c.Error as problem
+// details. Handlers must not write a response after attaching an error.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		writePendingError(c)
+	}
+}
+
+// Recovery turns a panic into a 500 problem response.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		writeProblem(c, fmt.Errorf("panic: %v", recovered))
+		c.Abort()
+	})
+}
+
+// NotFound is the handler for requests that match no route.
+func NotFound(c *gin.Context) {
+	c.Error(&statusError{status: http.StatusNotFound, problemType: ProblemTypeNotFound, detail: "No route matches the requested path."})
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/service"
+)
+
+type ScheduleHandler struct {
+	service *service.ScheduleService
+}
+
+func NewScheduleHandler(service *service.ScheduleService) *ScheduleHandler {
+	return &ScheduleHandler{service: service}
+}
+
+// ScheduleRequest is the body of POST /scheduled-transfers. Exactly one of
+// RunAt, Cron and Interval must be given; its rules are enforced by
+// ScheduleService.
+type ScheduleRequest struct {
+	FromID   int64      `json:"from_id"`
+	ToID     int64      `json:"to_id"`
+	Amount   int64      `json:"amount"`
+	Currency string     `json:"currency"`
+	RunAt    *time.Time `json:"run_at"`
+	Cron     string     `json:"cron"`
+	Interval string     `json:"interval"`
+	StartAt  *time.Time `json:"start_at"`
+	EndAt    *time.Time `json:"end_at"`
+	MaxRuns  *int       `json:"max_runs"`
+}
+
+// Создание разового или повторяющегося перевода по расписанию
+func (h *ScheduleHandler) CreateSchedule(c *gin.Context) {
+	var req ScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindingError(err))
+		return
+	}
+
+	st := models.ScheduledTransfer{
+		FromId:   req.FromID,
+		ToId:     req.ToID,
+		Amount:   req.Amount,
+		Currency: req.Currency,
+		RunAt:    req.RunAt,
+		Cron:     req.Cron,
+		Interval: req.Interval,
+		EndAt:    req.EndAt,
+		MaxRuns:  req.MaxRuns,
+	}
+	if req.StartAt != nil {
+		st.StartAt = *req.StartAt
+	}
+
+	created, err := h.service.CreateSchedule(c.Request.Context(), st)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+// Перевод по расписанию по id
+func (h *ScheduleHandler) GetSchedule(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(invalidField("id", "must be an integer"))
+		return
+	}
+
+	st, err := h.service.GetSchedule(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, st)
+}
+
+// Отмена перевода по расписанию
+func (h *ScheduleHandler) CancelSchedule(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(invalidField("id", "must be an integer"))
+		return
+	}
+
+	st, err := h.service.CancelSchedule(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, st)
+}
+
+// История запусков перевода по расписанию, новые первыми
+func (h *ScheduleHandler) ListScheduleRuns(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(invalidField("id", "must be an integer"))
+		return
+	}
+
+	limit := defaultListLimit
+	if v := c.Query("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			c.Error(invalidField("limit", "must be between 1 and "+strconv.Itoa(maxListLimit)))
+			return
+		}
+	}
+
+	runs, err := h.service.ListScheduleRuns(c.Request.Context(), id, limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
@@ -1,118 +1,454 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lahaehae/crud_project/internal/models"
-
-	//"github.com/lahaehae/crud_project/internal/repository"
+	"github.com/lahaehae/crud_project/internal/repository"
 	"github.com/lahaehae/crud_project/internal/service"
 )
 
 type UserHandler struct {
-	service *service.UserService
+	service        *service.UserService
+	requireIfMatch bool
 }
 
-func NewUserHandler(service *service.UserService) *UserHandler {
-	return &UserHandler{service: service}
+// NewUserHandler creates the user handlers. With requireIfMatch set, writes
+// to an existing user are refused with 428 unless they carry If-Match.
+func NewUserHandler(service *service.UserService, requireIfMatch bool) *UserHandler {
+	return &UserHandler{service: service, requireIfMatch: requireIfMatch}
 }
 
+const (
+	MergePatchContentType = "application/merge-patch+json"
+
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// TransferRequest is only decoded here; its rules are enforced by UserService.
+// Balance is in minor units of Currency, which must be the sender's.
 type TransferRequest struct {
-	FromID  int64 `json:"from_id" binding:"required"`
-	ToID    int64 `json:"to_id" binding:"required"`
-	Balance int64 `json:"balance" binding:"required"`
+	FromID   int64  `json:"from_id"`
+	ToID     int64  `json:"to_id"`
+	Balance  int64  `json:"balance"`
+	Currency string `json:"currency"`
+}
+
+// UpdateUserRequest is the body of PUT /users/:id. Balance is decoded only
+// to reject it: balances change through deposits, withdrawals and transfers.
+type UpdateUserRequest struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Balance *int64 `json:"balance"`
+}
+
+// AmountRequest is the body of a deposit or withdrawal.
+type AmountRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+const balanceReadOnly = "cannot be set directly, use deposits and withdrawals"
+
+// ReverseRequest is the optional body of a reversal; a missing or zero
+// amount refunds everything that is left.
+type ReverseRequest struct {
+	Amount int64 `json:"amount"`
 }
 
 // Создание пользователя
 func (h *UserHandler) CreateUser(c *gin.Context) {
 	var user models.User
 	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(bindingError(err))
 		return
 	}
 
-	newUser, err := h.service.CreateUser(c.Request.Context(), user.Name, user.Email, user.Balance)
+	newUser, err := h.service.CreateUser(c.Request.Context(), user.Name, user.Email, user.Currency, user.Balance)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
+	setETag(c, newUser.Version)
 	c.JSON(http.StatusOK, newUser)
 }
 
 func (h *UserHandler) TransferFunds(c *gin.Context){
 	var req TransferRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(bindingError(err))
 		return
 	}
 
-	transferFunds, err := h.service.TransferFunds(c.Request.Context(), req.FromID, req.ToID, req.Balance)
+	transfer, err := h.service.TransferFunds(c.Request.Context(), req.FromID, req.ToID, req.Balance, req.Currency)
 	if err != nil{
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction Failed"})
+		c.Error(err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Transfer successful",
-		"user": gin.H{
-			"id": transferFunds.Id,
-			"balance": transferFunds.Balance,
-		},
-	})
+	c.JSON(http.StatusOK, transfer)
 }
 
 // Получение пользователя по ID
 func (h *UserHandler) GetUser(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		c.Error(invalidField("id", "must be an integer"))
 		return
 	}
 
 	user, err := h.service.GetUser(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(err)
 		return
 	}
+	setETag(c, user.Version)
 	c.JSON(http.StatusOK, user)
 }
 
+// Поиск пользователя по email
+func (h *UserHandler) GetUserByEmail(c *gin.Context) {
+	user, err := h.service.GetUserByEmail(c.Request.Context(), c.Param("email"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	setETag(c, user.Version)
+	c.JSON(http.StatusOK, user)
+}
+
+// Список пользователей с фильтрацией и курсорной пагинацией
+func (h *UserHandler) ListUsers(c *gin.Context) {
+	filter := models.UserFilter{
+		Name:   c.Query("name"),
+		Email:  c.Query("email"),
+		Cursor: c.Query("cursor"),
+		SortBy: c.DefaultQuery("sort", models.UserSortByID),
+		Limit:  defaultListLimit,
+	}
+
+	switch filter.SortBy {
+	case models.UserSortByID, models.UserSortByName, models.UserSortByBalance:
+	default:
+		c.Error(invalidField("sort", "must be one of id, name, balance"))
+		return
+	}
+
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		c.Error(invalidField("order", "must be asc or desc"))
+		return
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			c.Error(invalidField("limit", "must be between 1 and "+strconv.Itoa(maxListLimit)))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	for param, dst := range map[string]**int64{
+		"min_balance": &filter.MinBalance,
+		"max_balance": &filter.MaxBalance,
+	} {
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.Error(invalidField(param, "must be an integer"))
+			return
+		}
+		*dst = &n
+	}
+
+	page, err := h.service.ListUsers(c.Request.Context(), filter)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
 // Обновление пользователя
 func (h *UserHandler) UpdateUser(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		c.Error(invalidField("id", "must be an integer"))
 		return
 	}
 
-	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindingError(err))
+		return
+	}
+	if req.Balance != nil {
+		c.Error(invalidField("balance", balanceReadOnly))
 		return
 	}
 
-	updatedUser, err := h.service.UpdateUser(c.Request.Context(), id, user.Name, user.Email, user.Balance)
+	version, err := h.ifMatchVersion(c, id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
+
+	updatedUser, err := h.service.UpdateUser(c.Request.Context(), id, req.Name, req.Email, version)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	setETag(c, updatedUser.Version)
 	c.JSON(http.StatusOK, updatedUser)
 }
 
+// Частичное обновление пользователя (JSON Merge Patch, RFC 7396)
+func (h *UserHandler) PatchUser(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(invalidField("id", "must be an integer"))
+		return
+	}
+
+	if c.ContentType() != MergePatchContentType {
+		c.Error(newStatusError(http.StatusUnsupportedMediaType, "Content-Type must be "+MergePatchContentType))
+		return
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.NewDecoder(c.Request.Body).Decode(&doc); err != nil || doc == nil {
+		c.Error(invalidField("body", "must be a JSON object"))
+		return
+	}
+	patch, err := parseUserPatch(doc)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	version, err := h.ifMatchVersion(c, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	user, err := h.service.PatchUser(c.Request.Context(), id, patch, version)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	setETag(c, user.Version)
+	c.JSON(http.StatusOK, user)
+}
+
+// parseUserPatch converts a merge patch document into a UserPatch. Every
+// user field is required in storage, so removing one with null is rejected.
+func parseUserPatch(doc map[string]json.RawMessage) (models.UserPatch, error) {
+	var (
+		patch models.UserPatch
+		verr  repository.ValidationError
+	)
+	for field, raw := range doc {
+		if string(raw) == "null" {
+			verr.Fields = append(verr.Fields, repository.FieldError{Field: field, Message: "cannot be removed"})
+			continue
+		}
+		var err error
+		switch field {
+		case "name":
+			err = json.Unmarshal(raw, &patch.Name)
+		case "email":
+			err = json.Unmarshal(raw, &patch.Email)
+		case "balance":
+			verr.Fields = append(verr.Fields, repository.FieldError{Field: field, Message: balanceReadOnly})
+			continue
+		default:
+			verr.Fields = append(verr.Fields, repository.FieldError{Field: field, Message: "cannot be patched"})
+			continue
+		}
+		if err != nil {
+			verr.Fields = append(verr.Fields, repository.FieldError{Field: field, Message: "has an invalid value"})
+		}
+	}
+	if len(verr.Fields) > 0 {
+		return patch, &verr
+	}
+	return patch, nil
+}
+
 // Удаление пользователя
 func (h *UserHandler) DeleteUser(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		c.Error(invalidField("id", "must be an integer"))
 		return
 	}
 
-	if err := h.service.DeleteUser(c.Request.Context(), id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	version, err := h.ifMatchVersion(c, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := h.service.DeleteUser(c.Request.Context(), id, version); err != nil {
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
 }
 
 
+
+// Перевод по id
+func (h *UserHandler) GetTransfer(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(invalidField("id", "must be an integer"))
+		return
+	}
+
+	transfer, err := h.service.GetTransfer(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, transfer)
+}
+
+// История переводов пользователя, новые первыми
+func (h *UserHandler) ListUserTransfers(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(invalidField("id", "must be an integer"))
+		return
+	}
+
+	filter := models.TransferFilter{
+		UserId:    id,
+		Direction: c.DefaultQuery("direction", models.TransferDirectionAll),
+		Cursor:    c.Query("cursor"),
+		Limit:     defaultListLimit,
+	}
+
+	switch filter.Direction {
+	case models.TransferDirectionAll, models.TransferDirectionIn, models.TransferDirectionOut:
+	default:
+		c.Error(invalidField("direction", "must be one of all, in, out"))
+		return
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			c.Error(invalidField("limit", "must be between 1 and "+strconv.Itoa(maxListLimit)))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	for param, dst := range map[string]**time.Time{
+		"since": &filter.Since,
+		"until": &filter.Until,
+	} {
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.Error(invalidField(param, "must be an RFC 3339 timestamp"))
+			return
+		}
+		*dst = &t
+	}
+
+	for param, dst := range map[string]**int64{
+		"min_amount": &filter.MinAmount,
+		"max_amount": &filter.MaxAmount,
+	} {
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.Error(invalidField(param, "must be an integer"))
+			return
+		}
+		*dst = &n
+	}
+
+	page, err := h.service.ListTransfers(c.Request.Context(), filter)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// Возврат перевода, полный или частичный
+func (h *UserHandler) ReverseTransfer(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(invalidField("id", "must be an integer"))
+		return
+	}
+
+	var req ReverseRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(bindingError(err))
+			return
+		}
+	}
+
+	reversal, err := h.service.ReverseTransfer(c.Request.Context(), id, req.Amount)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusCreated, reversal)
+}
+
+// Пополнение баланса
+func (h *UserHandler) Deposit(c *gin.Context) {
+	h.cashMovement(c, h.service.Deposit)
+}
+
+// Списание с баланса
+func (h *UserHandler) Withdraw(c *gin.Context) {
+	h.cashMovement(c, h.service.Withdraw)
+}
+
+func (h *UserHandler) cashMovement(c *gin.Context, move func(ctx context.Context, id, amount int64) (*models.User, error)) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(invalidField("id", "must be an integer"))
+		return
+	}
+
+	var req AmountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindingError(err))
+		return
+	}
+
+	user, err := move(c.Request.Context(), id, req.Amount)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	setETag(c, user.Version)
+	c.JSON(http.StatusOK, user)
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lahaehae/crud_project/internal/currency"
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/repository"
+	"github.com/lahaehae/crud_project/internal/service"
+)
+
+// newListRouter serves GET /users over an in-memory repository holding
+// users with the given names and balances, and returns their ids.
+func newListRouter(t *testing.T, users []models.User) (*gin.Engine, []int64) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	repo := repository.NewMemoryUserRepository()
+	rates, err := currency.NewStaticRates(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewUserService(repo, repo, rates, service.Config{})
+	ids := make([]int64, len(users))
+	for i, u := range users {
+		user, err := svc.CreateUser(context.Background(), u.Name, fmt.Sprintf("user%d@example.com", i), "", u.Balance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = user.Id
+	}
+
+	r := gin.New()
+	r.Use(ErrorHandler())
+	r.GET("/users", NewUserHandler(svc, false).ListUsers)
+	return r, ids
+}
+
+// listUsers requests GET /users with query and decodes the page.
+func listUsers(t *testing.T, r *gin.Engine, query url.Values) (int, models.UserPage) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users?"+query.Encode(), nil))
+	var page models.UserPage
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("decode page: %v", err)
+		}
+	}
+	return w.Code, page
+}
+
+// walk follows the cursors of query from the first page to the last and
+// returns the ids seen, checking that no page exceeds limit.
+func walk(t *testing.T, r *gin.Engine, query url.Values, limit int) []int64 {
+	t.Helper()
+	query.Set("limit", fmt.Sprint(limit))
+	var ids []int64
+	for pages := 0; ; pages++ {
+		if pages > 20 {
+			t.Fatal("cursors do not come to an end")
+		}
+		code, page := listUsers(t, r, query)
+		if code != http.StatusOK {
+			t.Fatalf("GET /users?%s: status %d", query.Encode(), code)
+		}
+		if len(page.Users) > limit {
+			t.Fatalf("page of %d users, limit %d", len(page.Users), limit)
+		}
+		for _, u := range page.Users {
+			ids = append(ids, u.Id)
+		}
+		if page.NextCursor == "" {
+			return ids
+		}
+		if len(page.Users) < limit {
+			t.Fatalf("short page of %d users has a next cursor", len(page.Users))
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+}
+
+func TestListUsersCursorRoundTrip(t *testing.T) {
+	// names and balances repeat, so that id has to break ties
+	r, ids := newListRouter(t, []models.User{
+		{Name: "Bob", Balance: 300},
+		{Name: "Ann", Balance: 100},
+		{Name: "Bob", Balance: 100},
+		{Name: "Cid", Balance: 300},
+		{Name: "Ann", Balance: 200},
+	})
+	pick := func(i ...int) []int64 {
+		out := make([]int64, len(i))
+		for j, k := range i {
+			out[j] = ids[k]
+		}
+		return out
+	}
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  []int64
+	}{
+		{"by id", url.Values{}, pick(0, 1, 2, 3, 4)},
+		{"by id descending", url.Values{"order": {"desc"}}, pick(4, 3, 2, 1, 0)},
+		{"by name", url.Values{"sort": {"name"}}, pick(1, 4, 0, 2, 3)},
+		{"by name descending", url.Values{"sort": {"name"}, "order": {"desc"}}, pick(3, 2, 0, 4, 1)},
+		{"by balance", url.Values{"sort": {"balance"}}, pick(1, 2, 4, 0, 3)},
+		{"by balance descending", url.Values{"sort": {"balance"}, "order": {"desc"}}, pick(3, 0, 4, 2, 1)},
+		{"filtered by name", url.Values{"sort": {"balance"}, "name": {"an"}}, pick(1, 4)},
+		{"filtered by balance", url.Values{"sort": {"name"}, "min_balance": {"150"}, "max_balance": {"300"}}, pick(4, 0, 3)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, limit := range []int{1, 2, 3, len(tt.want), 100} {
+				query := url.Values{}
+				for k, v := range tt.query {
+					query[k] = v
+				}
+				if got := walk(t, r, query, limit); !slices.Equal(got, tt.want) {
+					t.Errorf("limit %d: got %v, want %v", limit, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestListUsersLimit(t *testing.T) {
+	r, _ := newListRouter(t, []models.User{{Name: "Ann"}, {Name: "Bob"}})
+
+	tests := []struct {
+		limit      string
+		want       int
+		wantUsers  int
+		wantCursor bool
+	}{
+		{limit: "0", want: http.StatusBadRequest},
+		{limit: "-1", want: http.StatusBadRequest},
+		{limit: "101", want: http.StatusBadRequest},
+		{limit: "ten", want: http.StatusBadRequest},
+		{limit: "1", want: http.StatusOK, wantUsers: 1, wantCursor: true},
+		// a page that ends exactly at the last user has no next page
+		{limit: "2", want: http.StatusOK, wantUsers: 2},
+		{limit: "100", want: http.StatusOK, wantUsers: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.limit, func(t *testing.T) {
+			code, page := listUsers(t, r, url.Values{"limit": {tt.limit}})
+			if code != tt.want {
+				t.Fatalf("status %d, want %d", code, tt.want)
+			}
+			if len(page.Users) != tt.wantUsers || (page.NextCursor != "") != tt.wantCursor {
+				t.Errorf("got %d users and cursor %q, want %d users and a cursor: %v", len(page.Users), page.NextCursor, tt.wantUsers, tt.wantCursor)
+			}
+		})
+	}
+}
+
+func TestListUsersInvalidCursor(t *testing.T) {
+	r, _ := newListRouter(t, []models.User{{Name: "Ann"}, {Name: "Bob"}, {Name: "Cid"}})
+	_, first := listUsers(t, r, url.Values{"sort": {"name"}, "limit": {"1"}})
+	if first.NextCursor == "" {
+		t.Fatal("first page has no cursor")
+	}
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{"not base64", url.Values{"cursor": {"!!!"}}},
+		{"not json", url.Values{"cursor": {encode("id=1")}}},
+		{"wrong type", url.Values{"cursor": {encode(`{"s":"id","id":"one"}`)}}},
+		{"truncated", url.Values{"sort": {"name"}, "cursor": {first.NextCursor[:len(first.NextCursor)-3]}}},
+		{"other sort", url.Values{"sort": {"balance"}, "cursor": {first.NextCursor}}},
+		{"default sort", url.Values{"cursor": {first.NextCursor}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, _ := listUsers(t, r, tt.query); code != http.StatusBadRequest {
+				t.Errorf("status %d, want %d", code, http.StatusBadRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package health
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lahaehae/crud_project/internal/db/migrations"
+)
+
+// Database checks that a pooled connection can reach Postgres.
+func Database(pool *pgxpool.Pool) Checker {
+	return NewChecker("database", pool.Ping)
+}
+
+// Migrations checks that every migration known to this binary is applied.
+func Migrations(migrator *migrations.Migrator) Checker {
+	return NewChecker("migrations", func(ctx context.Context) error {
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d pending migration(s)", pending)
+		}
+		return nil
+	})
+}
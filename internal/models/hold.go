@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+const (
+	HoldStatusActive   = "active"
+	HoldStatusCaptured = "captured"
+	HoldStatusVoided   = "voided"
+	HoldStatusExpired  = "expired"
+)
+
+// Hold reserves Amount of a user's balance for a transfer to ToId. While
+// active it lowers the user's available balance but not the balance itself.
+// Capturing settles all or part of it as the transfer TransferId; whatever
+// is not captured is released.
+type Hold struct {
+	Id             int64     `json:"id"`
+	UserId         int64     `json:"user_id"`
+	ToId           int64     `json:"to_id"`
+	Amount         int64     `json:"amount"`
+	Currency       string    `json:"currency"`
+	Status         string    `json:"status"`
+	CapturedAmount int64     `json:"captured_amount"`
+	TransferId     *int64    `json:"transfer_id,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
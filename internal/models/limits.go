@@ -0,0 +1,41 @@
+package models
+
+// Names of the limits a transfer can exceed, as they appear in Limits.
+const (
+	LimitMaxAmount     = "max_amount"
+	LimitDailyAmount   = "daily_amount"
+	LimitMonthlyAmount = "monthly_amount"
+	LimitHourlyCount   = "hourly_count"
+)
+
+// Limits caps the outgoing transfers of an account. Amounts are in minor
+// units of the account's currency; days and months are calendar periods in
+// UTC, the hour is the last sixty minutes.
+//
+// As effective limits a nil field means unlimited. As per-user overrides a
+// nil field inherits the global limit and zero lifts it for that user.
+type Limits struct {
+	MaxAmount     *int64 `json:"max_amount"`
+	DailyAmount   *int64 `json:"daily_amount"`
+	MonthlyAmount *int64 `json:"monthly_amount"`
+	HourlyCount   *int64 `json:"hourly_count"`
+}
+
+// TransferUsage is what an account has sent in the periods Limits counts.
+// Captured holds and refunds count like any other transfer out of it.
+type TransferUsage struct {
+	DayAmount   int64 `json:"day_amount"`
+	MonthAmount int64 `json:"month_amount"`
+	HourCount   int64 `json:"hour_count"`
+}
+
+// AccountLimits is the admin view of a user's limits: the overrides set for
+// the user, the limits in force after applying them to the global ones, and
+// the current usage, all in the account's Currency.
+type AccountLimits struct {
+	UserId    int64         `json:"user_id"`
+	Currency  string        `json:"currency"`
+	Overrides Limits        `json:"overrides"`
+	Effective Limits        `json:"effective"`
+	Usage     TransferUsage `json:"usage"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+const (
+	ScheduleStatusActive    = "active"
+	ScheduleStatusCompleted = "completed"
+	ScheduleStatusCancelled = "cancelled"
+
+	ScheduleRunSucceeded = "succeeded"
+	ScheduleRunFailed    = "failed"
+)
+
+// ScheduledTransfer is a transfer made by the scheduler, either once at RunAt
+// or repeatedly by Cron (a five-field cron expression in UTC) or every
+// Interval (a Go duration such as "24h") counted from StartAt. Exactly one of
+// RunAt, Cron and Interval is set.
+//
+// A recurring schedule stops after EndAt or after MaxRuns occurrences.
+// NextRunAt is the next occurrence and is unset once the schedule is no
+// longer active; RetryAt is set while a failed occurrence waits for another
+// attempt.
+type ScheduledTransfer struct {
+	Id        int64      `json:"id"`
+	FromId    int64      `json:"from_id"`
+	ToId      int64      `json:"to_id"`
+	Amount    int64      `json:"amount"`
+	Currency  string     `json:"currency"`
+	RunAt     *time.Time `json:"run_at,omitempty"`
+	Cron      string     `json:"cron,omitempty"`
+	Interval  string     `json:"interval,omitempty"`
+	StartAt   time.Time  `json:"start_at"`
+	EndAt     *time.Time `json:"end_at,omitempty"`
+	MaxRuns   *int       `json:"max_runs,omitempty"`
+	RunCount  int        `json:"run_count"`
+	Attempt   int        `json:"attempt"`
+	Status    string     `json:"status"`
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+	RetryAt   *time.Time `json:"retry_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// ScheduledTransferRun is the outcome of one attempt at an occurrence of a
+// schedule. A failed attempt that will be retried carries RetryAt.
+type ScheduledTransferRun struct {
+	Id           int64      `json:"id"`
+	ScheduleId   int64      `json:"schedule_id"`
+	ScheduledFor time.Time  `json:"scheduled_for"`
+	Attempt      int        `json:"attempt"`
+	Status       string     `json:"status"`
+	TransferId   *int64     `json:"transfer_id,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	RetryAt      *time.Time `json:"retry_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
@@ -0,0 +1,81 @@
+package models
+
+import "time"
+
+const (
+	TransferStatusCompleted         = "completed"
+	TransferStatusPartiallyReversed = "partially_reversed"
+	TransferStatusReversed          = "reversed"
+)
+
+// Transfer is a completed movement of funds. A reversal is a transfer in the
+// opposite direction whose ReversalOf names the original; the original keeps
+// the running total of its reversals in ReversedAmount.
+//
+// Amount is debited in Currency. A transfer between accounts in different
+// currencies credits ToAmount of ToCurrency instead, converted at Rate units
+// of ToCurrency per unit of Currency; all three are unset otherwise.
+type Transfer struct {
+	Id             int64     `json:"id"`
+	FromId         int64     `json:"from_id"`
+	ToId           int64     `json:"to_id"`
+	Amount         int64     `json:"amount"`
+	Currency       string    `json:"currency"`
+	ToAmount       *int64    `json:"to_amount,omitempty"`
+	ToCurrency     *string   `json:"to_currency,omitempty"`
+	Rate           *string   `json:"rate,omitempty"`
+	Status         string    `json:"status"`
+	ReversalOf     *int64    `json:"reversal_of,omitempty"`
+	ReversedAmount int64     `json:"reversed_amount"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Credited is the amount and currency the recipient receives.
+func (t *Transfer) Credited() (int64, string) {
+	if t.ToAmount != nil {
+		return *t.ToAmount, *t.ToCurrency
+	}
+	return t.Amount, t.Currency
+}
+
+// Reversible is the amount that can still be refunded.
+func (t *Transfer) Reversible() int64 {
+	if t.ReversalOf != nil {
+		return 0
+	}
+	return t.Amount - t.ReversedAmount
+}
+
+// Directions of a transfer relative to the user whose history is listed.
+const (
+	TransferDirectionAll = "all"
+	TransferDirectionIn  = "in"
+	TransferDirectionOut = "out"
+)
+
+// TransferFilter selects a page of one user's transfer history, newest first.
+// Since is inclusive and Until exclusive.
+type TransferFilter struct {
+	UserId    int64
+	Direction string
+	Since     *time.Time
+	Until     *time.Time
+	MinAmount *int64
+	MaxAmount *int64
+	Cursor    string
+	Limit     int
+}
+
+type TransferPage struct {
+	Transfers  []Transfer `json:"transfers"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// TransferOrder is one transfer requested as part of a batch. Amount is in
+// minor units of Currency, which must be the sender's.
+type TransferOrder struct {
+	FromId   int64
+	ToId     int64
+	Amount   int64
+	Currency string
+}
@@ -1,8 +1,50 @@
 package models
 
+// User is an account holder. Balance is in minor units of Currency, an ISO
+// 4217 code whose minor unit has CurrencyExponent decimal digits; both are
+// fixed when the account is created. AvailableBalance is Balance less the
+// active holds, and is what transfers and withdrawals may spend.
 type User struct {
-	Id      int64  `json:"id"`
-	Name    string `json:"name"`
-	Email   string `json:"email"`
-	Balance int64  `json:"balance"`
+	Id               int64  `json:"id"`
+	Name             string `json:"name"`
+	Email            string `json:"email"`
+	Balance          int64  `json:"balance"`
+	AvailableBalance int64  `json:"available_balance"`
+	Currency         string `json:"currency"`
+	CurrencyExponent int    `json:"currency_exponent"`
+	Version          int64  `json:"version"`
+}
+
+
+// UserPatch holds the fields supplied in a partial update; nil fields are
+// left unchanged. The balance is not among them: it only changes through
+// ledger postings.
+type UserPatch struct {
+	Name  *string
+	Email *string
+}
+
+const (
+	UserSortByID      = "id"
+	UserSortByName    = "name"
+	UserSortByBalance = "balance"
+)
+
+// UserFilter describes a page of the user list. Cursor is the opaque value
+// returned as NextCursor by the previous page and must be used with the same
+// sort options.
+type UserFilter struct {
+	Name       string
+	Email      string
+	MinBalance *int64
+	MaxBalance *int64
+	SortBy     string
+	Desc       bool
+	Cursor     string
+	Limit      int
+}
+
+type UserPage struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
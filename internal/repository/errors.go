@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Domain errors returned by the repositories and passed through the service
+// layer unchanged. Callers should test for them with errors.Is.
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrConflict          = errors.New("conflict")
+	ErrValidation        = errors.New("validation failed")
+	// ErrReversalExceeded is returned when a refund is larger than what is
+	// left to reverse of a transfer.
+	ErrReversalExceeded = errors.New("reversal exceeds the reversible amount")
+	// ErrCurrencyMismatch is returned when a money movement names a currency
+	// other than that of an account it touches.
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	// ErrLimitExceeded is returned when a transfer would break one of the
+	// sender's transfer limits.
+	ErrLimitExceeded = errors.New("limit exceeded")
+
+	ErrInvalidCursor = fmt.Errorf("%w: invalid cursor", ErrValidation)
+	// ErrAccountNotFound is returned when an operation names a user account
+	// that does not exist.
+	ErrAccountNotFound = fmt.Errorf("%w: account", ErrNotFound)
+	// ErrVersionMismatch is returned when a conditional write names a version
+	// that is no longer the current one.
+	ErrVersionMismatch = fmt.Errorf("%w: version mismatch", ErrConflict)
+	// ErrAlreadyReversed is returned for a transfer that has been refunded in
+	// full, ErrNotReversible for a transfer that is itself a reversal.
+	ErrAlreadyReversed = fmt.Errorf("%w: transfer already reversed", ErrConflict)
+	ErrNotReversible   = fmt.Errorf("%w: a reversal cannot be reversed", ErrConflict)
+	// ErrHoldNotActive is returned when capturing or voiding a hold that has
+	// already been settled or has expired.
+	ErrHoldNotActive = fmt.Errorf("%w: hold is not active", ErrConflict)
+	// ErrScheduleNotActive is returned when cancelling a scheduled transfer
+	// that has already completed or been cancelled.
+	ErrScheduleNotActive = fmt.Errorf("%w: scheduled transfer is not active", ErrConflict)
+	// ErrAccountInUse is returned when deleting a user whose account still
+	// holds money or appears in the books.
+	ErrAccountInUse = fmt.Errorf("%w: account is in use", ErrConflict)
+)
+
+// FieldError describes a single invalid field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError carries every field violation found in a request.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return ErrValidation.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// ConflictError reports a write rejected by a unique constraint. Field names
+// the colliding attribute when it is known.
+type ConflictError struct {
+	Field      string
+	Constraint string
+}
+
+func (e *ConflictError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s: %s already exists", ErrConflict, e.Field)
+	}
+	return fmt.Sprintf("%s: %s", ErrConflict, e.Constraint)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// AccountNotFoundError names the missing account of a money movement.
+type AccountNotFoundError struct {
+	AccountID int64
+}
+
+func (e *AccountNotFoundError) Error() string {
+	return fmt.Sprintf("%s %d", ErrAccountNotFound, e.AccountID)
+}
+
+func (e *AccountNotFoundError) Is(target error) bool {
+	return target == ErrAccountNotFound || target == ErrNotFound
+}
+
+// InsufficientFundsError reports a debit larger than the account balance.
+// Balance is the available balance, net of active holds.
+type InsufficientFundsError struct {
+	AccountID int64
+	Balance   int64
+	Amount    int64
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return fmt.Sprintf("%s: account %d has %d, needs %d", ErrInsufficientFunds, e.AccountID, e.Balance, e.Amount)
+}
+
+func (e *InsufficientFundsError) Is(target error) bool {
+	return target == ErrInsufficientFunds
+}
+
+// ReversalExceededError reports a refund larger than the reversible rest of
+// a transfer.
+type ReversalExceededError struct {
+	TransferID int64
+	Reversible int64
+	Amount     int64
+}
+
+func (e *ReversalExceededError) Error() string {
+	return fmt.Sprintf("%s: transfer %d has %d left, asked for %d", ErrReversalExceeded, e.TransferID, e.Reversible, e.Amount)
+}
+
+func (e *ReversalExceededError) Is(target error) bool {
+	return target == ErrReversalExceeded
+}
+
+// HoldNotActiveError names the hold that can no longer be settled and the
+// status it has reached.
+type HoldNotActiveError struct {
+	HoldID int64
+	Status string
+}
+
+func (e *HoldNotActiveError) Error() string {
+	return fmt.Sprintf("%s: hold %d is %s", ErrHoldNotActive, e.HoldID, e.Status)
+}
+
+func (e *HoldNotActiveError) Is(target error) bool {
+	return target == ErrHoldNotActive || target == ErrConflict
+}
+
+// AccountInUseError explains why an account cannot be deleted. Balance and
+// Held are what the account still holds and reserves; with both zero it is
+// the account's ledger and transfer history that must not be orphaned.
+type AccountInUseError struct {
+	AccountID int64
+	Balance   int64
+	Held      int64
+}
+
+func (e *AccountInUseError) Error() string {
+	if e.Balance == 0 && e.Held == 0 {
+		return fmt.Sprintf("%s: account %d has history", ErrAccountInUse, e.AccountID)
+	}
+	return fmt.Sprintf("%s: account %d has a balance of %d, %d held", ErrAccountInUse, e.AccountID, e.Balance, e.Held)
+}
+
+func (e *AccountInUseError) Is(target error) bool {
+	return target == ErrAccountInUse || target == ErrConflict
+}
+
+// CurrencyMismatchError reports an account held in Currency where Expected
+// was required.
+type CurrencyMismatchError struct {
+	AccountID int64
+	Currency  string
+	Expected  string
+}
+
+func (e *CurrencyMismatchError) Error() string {
+	return fmt.Sprintf("%s: account %d is in %s, not %s", ErrCurrencyMismatch, e.AccountID, e.Currency, e.Expected)
+}
+
+func (e *CurrencyMismatchError) Is(target error) bool {
+	return target == ErrCurrencyMismatch
+}
+
+// LimitExceededError names the limit a transfer of Amount would break.
+// Allowed is the limit and Used what counts against it already: an amount
+// for the amount limits, a number of transfers for hourly_count.
+type LimitExceededError struct {
+	AccountID int64
+	Limit     string
+	Allowed   int64
+	Used      int64
+	Amount    int64
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s: account %d %s is %d, used %d, needs %d", ErrLimitExceeded, e.AccountID, e.Limit, e.Allowed, e.Used, e.Amount)
+}
+
+func (e *LimitExceededError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// BatchItemError reports the transfer that made an all-or-nothing batch
+// fail. Index is its position in the batch.
+type BatchItemError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchItemError) Error() string {
+	return fmt.Sprintf("batch transfer %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchItemError) Unwrap() error {
+	return e.Err
+}
+
+// Postgres error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgNotNullViolation       = "23502"
+	pgForeignKeyViolation    = "23503"
+	pgUniqueViolation        = "23505"
+	pgCheckViolation         = "23514"
+	pgNumericValueOutOfRange = "22003"
+	pgSerializationFailure   = "40001"
+	pgDeadlockDetected       = "40P01"
+)
+
+// uniqueConstraintFields maps unique constraints to the field they protect.
+var uniqueConstraintFields = map[string]string{
+	"users_pkey":            "id",
+	"users_email_lower_key": "email",
+	"transfers_pkey":        "id",
+}
+
+// mapError translates driver errors into the domain errors above. Errors it
+// does not recognise are returned unchanged.
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	switch pgErr.Code {
+	case pgCheckViolation:
+		if pgErr.ConstraintName == "users_balance_check" || pgErr.ConstraintName == "users_held_check" {
+			return fmt.Errorf("%w: %w", ErrInsufficientFunds, err)
+		}
+		return fmt.Errorf("%w: %w", ErrValidation, err)
+	case pgUniqueViolation:
+		return fmt.Errorf("%w: %w", &ConflictError{
+			Field:      uniqueConstraintFields[pgErr.ConstraintName],
+			Constraint: pgErr.ConstraintName,
+		}, err)
+	case pgNotNullViolation, pgForeignKeyViolation, pgNumericValueOutOfRange:
+		return fmt.Errorf("%w: %w", ErrValidation, err)
+	}
+	return err
+}
@@ -0,0 +1,315 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lahaehae/crud_project/internal/currency"
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const holdColumns = "id, user_id, to_id, amount, currency, status, captured_amount, transfer_id, expires_at, created_at, updated_at"
+
+func scanHold(row rowScanner, h *models.Hold) error {
+	return row.Scan(&h.Id, &h.UserId, &h.ToId, &h.Amount, &h.Currency, &h.Status, &h.CapturedAmount,
+		&h.TransferId, &h.ExpiresAt, &h.CreatedAt, &h.UpdatedAt)
+}
+
+// CreateHold reserves amount of a user's available balance for a later
+// transfer to toId. The hold lapses at expiresAt unless it is settled first.
+func (r *UserRepository) CreateHold(ctx context.Context, userId, toId, amount int64, currency string, expiresAt time.Time) (*models.Hold, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.CreateHold")
+	defer span.End()
+
+	start := time.Now()
+
+	var hold models.Hold
+	err := r.tx.WithinTx(ctx, func(ctx context.Context) error {
+		accounts, err := r.lockAccounts(ctx, userId, toId)
+		if err != nil {
+			return err
+		}
+		if err := checkHold(accounts, userId, toId, amount, currency); err != nil {
+			return err
+		}
+		if err := r.execOne(ctx, "UPDATE users SET held = held + $1, version = version + 1 WHERE id = $2", userId, amount, userId); err != nil {
+			telemetry.RecordErrorMetric(ctx, "reserve_hold", err)
+			return err
+		}
+		query := `INSERT INTO holds (user_id, to_id, amount, currency, status, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING ` + holdColumns
+		err = scanHold(r.conn(ctx).QueryRow(ctx, query, userId, toId, amount, currency, models.HoldStatusActive, expiresAt), &hold)
+		if err != nil {
+			telemetry.RecordErrorMetric(ctx, "insert_hold", err)
+			return mapError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.Int64("db_query.user_id", userId),
+		attribute.Int64("db_query.hold_id", hold.Id),
+	)
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return &hold, nil
+}
+
+// checkHold verifies a hold of amount against the locked accounts involved.
+// Only the holder's currency must match: a recipient in another currency is
+// paid at the rate of the day the hold is captured.
+func checkHold(accounts map[int64]account, userId, toId, amount int64, currency string) error {
+	for _, id := range []int64{userId, toId} {
+		if _, ok := accounts[id]; !ok {
+			return &AccountNotFoundError{AccountID: id}
+		}
+	}
+	holder := accounts[userId]
+	if holder.Currency != currency {
+		return &CurrencyMismatchError{AccountID: userId, Currency: holder.Currency, Expected: currency}
+	}
+	if holder.Available() < amount {
+		return &InsufficientFundsError{AccountID: userId, Balance: holder.Available(), Amount: amount}
+	}
+	return nil
+}
+
+// GetHold returns a single hold by id.
+func (r *UserRepository) GetHold(ctx context.Context, id int64) (*models.Hold, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.GetHold")
+	defer span.End()
+
+	start := time.Now()
+
+	var hold models.Hold
+	query := "SELECT " + holdColumns + " FROM holds WHERE id = $1"
+	if err := scanHold(r.conn(ctx).QueryRow(ctx, query, id), &hold); err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "get_hold", err)
+		return nil, mapError(err)
+	}
+
+	span.SetAttributes(attribute.Int64("db_query.hold_id", id))
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return &hold, nil
+}
+
+// LockHold reads hold id and locks it until the transaction ends. Callers
+// that go on to lock the accounts of the hold must lock the hold first, as
+// CaptureHold, VoidHold and ExpireHolds do.
+func (r *UserRepository) LockHold(ctx context.Context, id int64) (*models.Hold, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.LockHold")
+	defer span.End()
+
+	start := time.Now()
+
+	hold, err := r.lockHold(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int64("db_query.hold_id", id))
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return hold, nil
+}
+
+// CaptureHold settles amount of hold id, or all of it when amount is zero,
+// as a transfer to the hold's recipient and releases the rest. quote must
+// be given when the recipient holds another currency than the hold.
+func (r *UserRepository) CaptureHold(ctx context.Context, id, amount int64, quote *currency.Quote) (*models.Transfer, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.CaptureHold")
+	defer span.End()
+
+	start := time.Now()
+
+	var transfer models.Transfer
+	err := r.tx.WithinTx(ctx, func(ctx context.Context) error {
+		hold, err := r.lockHold(ctx, id)
+		if err != nil {
+			return err
+		}
+		captured, err := planCapture(hold, amount, time.Now())
+		if err != nil {
+			return err
+		}
+		if transfer, err = holdTransfer(hold, captured, quote); err != nil {
+			return err
+		}
+		// the hold is locked first and the accounts after it, in id order,
+		// like VoidHold and ExpireHolds do
+		if _, err := r.lockAccounts(ctx, hold.UserId, hold.ToId); err != nil {
+			return err
+		}
+		if err := r.release(ctx, hold); err != nil {
+			return err
+		}
+		if err := r.transfer(ctx, &transfer); err != nil {
+			return err
+		}
+		query := "UPDATE holds SET status = $1, captured_amount = $2, transfer_id = $3, updated_at = now() WHERE id = $4"
+		if _, err := r.conn(ctx).Exec(ctx, query, models.HoldStatusCaptured, captured, transfer.Id, id); err != nil {
+			telemetry.RecordErrorMetric(ctx, "capture_hold", err)
+			return mapError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.Int64("db_query.hold_id", id),
+		attribute.Int64("db_query.transfer_id", transfer.Id),
+	)
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return &transfer, nil
+}
+
+// VoidHold releases hold id without moving any money.
+func (r *UserRepository) VoidHold(ctx context.Context, id int64) (*models.Hold, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.VoidHold")
+	defer span.End()
+
+	start := time.Now()
+
+	var hold *models.Hold
+	err := r.tx.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		if hold, err = r.lockHold(ctx, id); err != nil {
+			return err
+		}
+		if err := checkActive(hold, time.Now()); err != nil {
+			return err
+		}
+		if err := r.release(ctx, hold); err != nil {
+			return err
+		}
+		query := "UPDATE holds SET status = $1, updated_at = now() WHERE id = $2 RETURNING " + holdColumns
+		if err := scanHold(r.conn(ctx).QueryRow(ctx, query, models.HoldStatusVoided, id), hold); err != nil {
+			telemetry.RecordErrorMetric(ctx, "void_hold", err)
+			return mapError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int64("db_query.hold_id", id))
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return hold, nil
+}
+
+// ExpireHolds releases every active hold past its expiry and reports how
+// many there were. Holds locked by a concurrent capture or void are left to
+// that request, or to the next sweep.
+func (r *UserRepository) ExpireHolds(ctx context.Context) (int64, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.ExpireHolds")
+	defer span.End()
+
+	query := `WITH expired AS (
+			UPDATE holds SET status = $1, updated_at = now()
+			WHERE id IN (
+				SELECT id FROM holds WHERE status = $2 AND expires_at <= now()
+				ORDER BY id FOR UPDATE SKIP LOCKED
+			)
+			RETURNING user_id, amount
+		), released AS (
+			UPDATE users u SET held = u.held - e.amount, version = u.version + 1
+			FROM (SELECT user_id, sum(amount) AS amount FROM expired GROUP BY user_id) e
+			WHERE u.id = e.user_id
+		)
+		SELECT count(*) FROM expired`
+	var n int64
+	if err := r.conn(ctx).QueryRow(ctx, query, models.HoldStatusExpired, models.HoldStatusActive).Scan(&n); err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "expire_holds", err)
+		return 0, mapError(err)
+	}
+	span.SetAttributes(attribute.Int64("db_query.rows", n))
+	return n, nil
+}
+
+// lockHold reads hold id and locks it until the transaction ends.
+func (r *UserRepository) lockHold(ctx context.Context, id int64) (*models.Hold, error) {
+	var hold models.Hold
+	query := "SELECT " + holdColumns + " FROM holds WHERE id = $1 FOR UPDATE"
+	if err := scanHold(r.conn(ctx).QueryRow(ctx, query, id), &hold); err != nil {
+		telemetry.RecordErrorMetric(ctx, "lock_hold", err)
+		return nil, mapError(err)
+	}
+	return &hold, nil
+}
+
+// release gives the amount reserved by hold back to the holder.
+func (r *UserRepository) release(ctx context.Context, hold *models.Hold) error {
+	query := "UPDATE users SET held = held - $1, version = version + 1 WHERE id = $2"
+	if err := r.execOne(ctx, query, hold.UserId, hold.Amount, hold.UserId); err != nil {
+		telemetry.RecordErrorMetric(ctx, "release_hold", err)
+		return err
+	}
+	return nil
+}
+
+// checkActive rejects settling a hold that is no longer active. A hold past
+// its expiry counts as expired even before the sweeper has marked it.
+func checkActive(hold *models.Hold, now time.Time) error {
+	if hold.Status == models.HoldStatusActive && !now.Before(hold.ExpiresAt) {
+		return &HoldNotActiveError{HoldID: hold.Id, Status: models.HoldStatusExpired}
+	}
+	if hold.Status != models.HoldStatusActive {
+		return &HoldNotActiveError{HoldID: hold.Id, Status: hold.Status}
+	}
+	return nil
+}
+
+// planCapture checks that amount of hold can be captured and returns the
+// amount to transfer; zero captures the whole hold.
+func planCapture(hold *models.Hold, amount int64, now time.Time) (int64, error) {
+	if err := checkActive(hold, now); err != nil {
+		return 0, err
+	}
+	if amount == 0 {
+		amount = hold.Amount
+	}
+	if amount > hold.Amount {
+		return 0, &ValidationError{Fields: []FieldError{{
+			Field:   "amount",
+			Message: fmt.Sprintf("must be at most %d, the amount held", hold.Amount),
+		}}}
+	}
+	return amount, nil
+}
+
+// holdTransfer is the transfer that captures amount of hold.
+func holdTransfer(hold *models.Hold, amount int64, quote *currency.Quote) (models.Transfer, error) {
+	if quote != nil {
+		return exchange(hold.UserId, hold.ToId, amount, *quote)
+	}
+	return models.Transfer{
+		FromId:   hold.UserId,
+		ToId:     hold.ToId,
+		Amount:   amount,
+		Currency: hold.Currency,
+		Status:   models.TransferStatusCompleted,
+	}, nil
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// IdempotencyStore remembers the responses given to idempotent requests.
+type IdempotencyStore interface {
+	Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (*models.IdempotencyRecord, bool, error)
+	Complete(ctx context.Context, key string, statusCode int, contentType string, headers map[string]string, body []byte) error
+	Release(ctx context.Context, key string) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type IdempotencyRepository struct {
+	db     *pgxpool.Pool
+	tracer trace.Tracer
+}
+
+func NewIdempotencyRepository(db *pgxpool.Pool) *IdempotencyRepository {
+	return &IdempotencyRepository{
+		db:     db,
+		tracer: otel.Tracer("repository"),
+	}
+}
+
+// Reserve claims key for a new request. When the key is already taken by a
+// live record, that record is returned together with reserved == false.
+func (r *IdempotencyRepository) Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (*models.IdempotencyRecord, bool, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.ReserveIdempotencyKey")
+	defer span.End()
+
+	start := time.Now()
+
+	// An expired key may be reused as if it had never been seen.
+	_, err := r.db.Exec(ctx, "DELETE FROM idempotency_keys WHERE key = $1 AND expires_at <= now()", key)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "delete_expired_idempotency_key", err)
+		return nil, false, mapError(err)
+	}
+
+	query := `INSERT INTO idempotency_keys (key, fingerprint, expires_at)
+		VALUES ($1, $2, now() + $3::interval)
+		ON CONFLICT (key) DO NOTHING`
+	tag, err := r.db.Exec(ctx, query, key, fingerprint, ttl)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "insert_idempotency_key", err)
+		return nil, false, mapError(err)
+	}
+	span.SetAttributes(attribute.Bool("idempotency.reserved", tag.RowsAffected() == 1))
+	if tag.RowsAffected() == 1 {
+		if telemetry.RepoLatencyRecorder != nil {
+			telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+		}
+		return nil, true, nil
+	}
+
+	var (
+		record      models.IdempotencyRecord
+		statusCode  *int
+		contentType *string
+		headers     []byte
+	)
+	query = `SELECT key, fingerprint, status_code, content_type, response_headers, response_body, created_at, expires_at
+		FROM idempotency_keys WHERE key = $1`
+	err = r.db.QueryRow(ctx, query, key).Scan(
+		&record.Key, &record.Fingerprint, &statusCode, &contentType, &headers,
+		&record.ResponseBody, &record.CreatedAt, &record.ExpiresAt,
+	)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "select_idempotency_key", err)
+		return nil, false, mapError(err)
+	}
+	if statusCode != nil {
+		record.StatusCode = *statusCode
+	}
+	if contentType != nil {
+		record.ContentType = *contentType
+	}
+	// keys completed before headers were stored have none
+	if headers != nil {
+		if err := json.Unmarshal(headers, &record.Headers); err != nil {
+			span.RecordError(err)
+			return nil, false, fmt.Errorf("idempotency key %q: response headers: %w", key, err)
+		}
+	}
+
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return &record, false, nil
+}
+
+// Complete stores the response produced for a reserved key so that it can be
+// replayed to retries.
+func (r *IdempotencyRepository) Complete(ctx context.Context, key string, statusCode int, contentType string, headers map[string]string, body []byte) error {
+	ctx, span := r.tracer.Start(ctx, "Repository.CompleteIdempotencyKey")
+	defer span.End()
+
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	query := `UPDATE idempotency_keys
+		SET status_code = $1, content_type = $2, response_headers = $3, response_body = $4
+		WHERE key = $5`
+	_, err = r.db.Exec(ctx, query, statusCode, contentType, string(encoded), body, key)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "complete_idempotency_key", err)
+		return mapError(err)
+	}
+	return nil
+}
+
+// Release drops a reservation whose request did not produce a replayable
+// response, allowing the client to retry with the same key.
+func (r *IdempotencyRepository) Release(ctx context.Context, key string) error {
+	ctx, span := r.tracer.Start(ctx, "Repository.ReleaseIdempotencyKey")
+	defer span.End()
+
+	_, err := r.db.Exec(ctx, "DELETE FROM idempotency_keys WHERE key = $1 AND status_code IS NULL", key)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "release_idempotency_key", err)
+		return mapError(err)
+	}
+	return nil
+}
+
+// DeleteExpired removes every expired key and reports how many were removed.
+func (r *IdempotencyRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.DeleteExpiredIdempotencyKeys")
+	defer span.End()
+
+	tag, err := r.db.Exec(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= now()")
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "delete_expired_idempotency_keys", err)
+		return 0, mapError(err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/telemetry"
+)
+
+// Kinds of ledger transactions.
+const (
+	LedgerDeposit    = "deposit"
+	LedgerWithdrawal = "withdrawal"
+	LedgerTransfer   = "transfer"
+)
+
+// System accounts are the other side of money entering or leaving the
+// books: cash for deposits and withdrawals, opening for the balances that
+// existed before the ledger, fx for the currency exchanged by converted
+// transfers. A system account has a separate balance in every currency.
+const (
+	SystemAccountCash    = "cash"
+	SystemAccountOpening = "opening"
+	SystemAccountFX      = "fx"
+)
+
+// ledgerEntry is one side of a ledger transaction: a credit when Amount is
+// positive, a debit when negative. Exactly one of UserId and SystemAccount
+// is set.
+type ledgerEntry struct {
+	UserId        int64
+	SystemAccount string
+	Currency      string
+	Amount        int64
+}
+
+func userEntry(userId int64, currency string, amount int64) ledgerEntry {
+	return ledgerEntry{UserId: userId, Currency: currency, Amount: amount}
+}
+
+func systemEntry(account, currency string, amount int64) ledgerEntry {
+	return ledgerEntry{SystemAccount: account, Currency: currency, Amount: amount}
+}
+
+// transferEntries books t: the sender is debited in t.Currency and the
+// recipient credited in the currency it receives. A converted transfer
+// passes both sides through the fx account so that every currency balances
+// on its own.
+func transferEntries(t *models.Transfer) []ledgerEntry {
+	credited, toCurrency := t.Credited()
+	if toCurrency == t.Currency {
+		return []ledgerEntry{
+			userEntry(t.FromId, t.Currency, -t.Amount),
+			userEntry(t.ToId, t.Currency, t.Amount),
+		}
+	}
+	return []ledgerEntry{
+		userEntry(t.FromId, t.Currency, -t.Amount),
+		systemEntry(SystemAccountFX, t.Currency, t.Amount),
+		systemEntry(SystemAccountFX, toCurrency, -credited),
+		userEntry(t.ToId, toCurrency, credited),
+	}
+}
+
+// checkBalanced rejects entries that would create or destroy money in any
+// currency.
+func checkBalanced(entries []ledgerEntry) error {
+	sums := map[string]int64{}
+	for _, e := range entries {
+		sums[e.Currency] += e.Amount
+	}
+	for currency, sum := range sums {
+		if sum != 0 {
+			return fmt.Errorf("ledger entries do not balance: %s off by %d", currency, sum)
+		}
+	}
+	return nil
+}
+
+// post records a balanced ledger transaction and applies its user entries
+// to the cached balances. It must run inside a transaction; callers lock and
+// check the accounts involved first.
+func (r *UserRepository) post(ctx context.Context, kind string, transferId *int64, entries ...ledgerEntry) error {
+	if err := checkBalanced(entries); err != nil {
+		return err
+	}
+
+	var txId int64
+	query := "INSERT INTO ledger_transactions (kind, transfer_id) VALUES ($1, $2) RETURNING id"
+	if err := r.conn(ctx).QueryRow(ctx, query, kind, transferId).Scan(&txId); err != nil {
+		telemetry.RecordErrorMetric(ctx, "insert_ledger_transaction", err)
+		return mapError(err)
+	}
+
+	for _, e := range entries {
+		var (
+			userId  *int64
+			account *string
+		)
+		if e.SystemAccount != "" {
+			account = &e.SystemAccount
+		} else {
+			userId = &e.UserId
+		}
+		query := "INSERT INTO ledger_entries (transaction_id, user_id, system_account, currency, amount) VALUES ($1, $2, $3, $4, $5)"
+		if _, err := r.conn(ctx).Exec(ctx, query, txId, userId, account, e.Currency, e.Amount); err != nil {
+			telemetry.RecordErrorMetric(ctx, "insert_ledger_entry", err)
+			return mapError(err)
+		}
+		if userId == nil {
+			continue
+		}
+		query = "UPDATE users SET balance = balance + $1, version = version + 1 WHERE id = $2"
+		if err := r.execOne(ctx, query, e.UserId, e.Amount, e.UserId); err != nil {
+			telemetry.RecordErrorMetric(ctx, "update_balance", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// account is the part of a user row that money movements check.
+type account struct {
+	Balance  int64
+	Held     int64
+	Currency string
+}
+
+// Available is the balance that is not reserved by holds.
+func (a account) Available() int64 {
+	return a.Balance - a.Held
+}
+
+// lockAccounts locks the rows of the given users in id order, so that
+// concurrent movements between the same users cannot deadlock, and returns
+// their balances, holds and currencies. Missing users are absent from the
+// result.
+func (r *UserRepository) lockAccounts(ctx context.Context, ids ...int64) (map[int64]account, error) {
+	rows, err := r.conn(ctx).Query(ctx, "SELECT id, balance, held, currency FROM users WHERE id = ANY($1) ORDER BY id FOR UPDATE", ids)
+	if err != nil {
+		telemetry.RecordErrorMetric(ctx, "lock_users", err)
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	accounts := map[int64]account{}
+	for rows.Next() {
+		var (
+			id int64
+			a  account
+		)
+		if err := rows.Scan(&id, &a.Balance, &a.Held, &a.Currency); err != nil {
+			telemetry.RecordErrorMetric(ctx, "lock_users", err)
+			return nil, mapError(err)
+		}
+		accounts[id] = a
+	}
+	if err := rows.Err(); err != nil {
+		telemetry.RecordErrorMetric(ctx, "lock_users", err)
+		return nil, mapError(err)
+	}
+	return accounts, nil
+}
+
+// Deposit credits amount to a user from the cash account.
+func (r *UserRepository) Deposit(ctx context.Context, id, amount int64) (*models.User, error) {
+	return r.cashMovement(ctx, "Repository.Deposit", LedgerDeposit, id, amount)
+}
+
+// Withdraw debits amount from a user to the cash account.
+func (r *UserRepository) Withdraw(ctx context.Context, id, amount int64) (*models.User, error) {
+	return r.cashMovement(ctx, "Repository.Withdraw", LedgerWithdrawal, id, -amount)
+}
+
+// cashMovement books delta against the cash account in the user's currency
+// and returns the user as stored afterwards.
+func (r *UserRepository) cashMovement(ctx context.Context, spanName, kind string, id, delta int64) (*models.User, error) {
+	ctx, span := r.tracer.Start(ctx, spanName)
+	defer span.End()
+
+	var user *models.User
+	err := r.tx.WithinTx(ctx, func(ctx context.Context) error {
+		accounts, err := r.lockAccounts(ctx, id)
+		if err != nil {
+			return err
+		}
+		a, ok := accounts[id]
+		if !ok {
+			return &AccountNotFoundError{AccountID: id}
+		}
+		if a.Available()+delta < 0 {
+			return &InsufficientFundsError{AccountID: id, Balance: a.Available(), Amount: -delta}
+		}
+		if err := r.post(ctx, kind, nil, userEntry(id, a.Currency, delta), systemEntry(SystemAccountCash, a.Currency, -delta)); err != nil {
+			return err
+		}
+		user, err = r.GetUser(ctx, id)
+		return err
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// LockAccounts locks the rows of the given users in id order until the
+// transaction ends, so that checks made against their history stay valid
+// until a transfer is recorded.
+func (r *UserRepository) LockAccounts(ctx context.Context, ids ...int64) error {
+	_, err := r.lockAccounts(ctx, ids...)
+	return err
+}
+
+// usageWindows returns the starts of the periods counted by TransferUsage.
+func usageWindows(now time.Time) (day, month, hour time.Time) {
+	now = now.UTC()
+	day = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return day, month, now.Add(-time.Hour)
+}
+
+// TransferUsage sums the transfers userId has sent in the current UTC day
+// and month and counts those of the last hour, refunds of transfers it
+// received included.
+func (r *UserRepository) TransferUsage(ctx context.Context, userId int64, now time.Time) (*models.TransferUsage, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.TransferUsage")
+	defer span.End()
+
+	start := time.Now()
+
+	day, month, hour := usageWindows(now)
+	query := `SELECT
+			COALESCE(sum(amount) FILTER (WHERE created_at >= $2), 0),
+			COALESCE(sum(amount) FILTER (WHERE created_at >= $3), 0),
+			count(*) FILTER (WHERE created_at >= $4)
+		FROM transfers
+		WHERE from_id = $1 AND created_at >= LEAST($3, $4)`
+	var usage models.TransferUsage
+	if err := r.conn(ctx).QueryRow(ctx, query, userId, day, month, hour).Scan(&usage.DayAmount, &usage.MonthAmount, &usage.HourCount); err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "transfer_usage", err)
+		return nil, mapError(err)
+	}
+
+	span.SetAttributes(attribute.Int64("db_query.user_id", userId))
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return &usage, nil
+}
+
+// GetLimits returns the limit overrides of a user; a user without any has
+// all fields nil.
+func (r *UserRepository) GetLimits(ctx context.Context, userId int64) (*models.Limits, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.GetLimits")
+	defer span.End()
+
+	start := time.Now()
+
+	query := `SELECT l.max_amount, l.daily_amount, l.monthly_amount, l.hourly_count
+		FROM users u LEFT JOIN user_limits l ON l.user_id = u.id
+		WHERE u.id = $1`
+	var limits models.Limits
+	err := r.conn(ctx).QueryRow(ctx, query, userId).Scan(&limits.MaxAmount, &limits.DailyAmount, &limits.MonthlyAmount, &limits.HourlyCount)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "get_limits", err)
+		return nil, mapError(err)
+	}
+
+	span.SetAttributes(attribute.Int64("db_query.user_id", userId))
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return &limits, nil
+}
+
+// SetLimits replaces the limit overrides of a user.
+func (r *UserRepository) SetLimits(ctx context.Context, userId int64, limits models.Limits) (*models.Limits, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.SetLimits")
+	defer span.End()
+
+	start := time.Now()
+
+	query := `INSERT INTO user_limits (user_id, max_amount, daily_amount, monthly_amount, hourly_count)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET
+			max_amount = EXCLUDED.max_amount,
+			daily_amount = EXCLUDED.daily_amount,
+			monthly_amount = EXCLUDED.monthly_amount,
+			hourly_count = EXCLUDED.hourly_count,
+			updated_at = now()
+		RETURNING max_amount, daily_amount, monthly_amount, hourly_count`
+	var stored models.Limits
+	err := r.conn(ctx).QueryRow(ctx, query, userId, limits.MaxAmount, limits.DailyAmount, limits.MonthlyAmount, limits.HourlyCount).
+		Scan(&stored.MaxAmount, &stored.DailyAmount, &stored.MonthlyAmount, &stored.HourlyCount)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "set_limits", err)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+			return nil, fmt.Errorf("%w: user %d", ErrNotFound, userId)
+		}
+		return nil, mapError(err)
+	}
+
+	span.SetAttributes(attribute.Int64("db_query.user_id", userId))
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return &stored, nil
+}
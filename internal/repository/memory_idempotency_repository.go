@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/lahaehae/crud_project/internal/models"
+)
+
+// MemoryIdempotencyRepository is an IdempotencyStore kept in process memory.
+type MemoryIdempotencyRepository struct {
+	mu      sync.Mutex
+	records map[string]models.IdempotencyRecord
+}
+
+var _ IdempotencyStore = (*MemoryIdempotencyRepository)(nil)
+
+func NewMemoryIdempotencyRepository() *MemoryIdempotencyRepository {
+	return &MemoryIdempotencyRepository{records: map[string]models.IdempotencyRecord{}}
+}
+
+func (r *MemoryIdempotencyRepository) Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (*models.IdempotencyRecord, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if record, ok := r.records[key]; ok && record.ExpiresAt.After(now) {
+		return &record, false, nil
+	}
+	r.records[key] = models.IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+	return nil, true, nil
+}
+
+func (r *MemoryIdempotencyRepository) Complete(ctx context.Context, key string, statusCode int, contentType string, headers map[string]string, body []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.records[key]
+	if !ok {
+		return nil
+	}
+	record.StatusCode = statusCode
+	record.ContentType = contentType
+	record.Headers = maps.Clone(headers)
+	record.ResponseBody = append([]byte(nil), body...)
+	r.records[key] = record
+	return nil
+}
+
+func (r *MemoryIdempotencyRepository) Release(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if record, ok := r.records[key]; ok && record.StatusCode == 0 {
+		delete(r.records, key)
+	}
+	return nil
+}
+
+func (r *MemoryIdempotencyRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var n int64
+	for key, record := range r.records {
+		if !record.ExpiresAt.After(now) {
+			delete(r.records, key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lahaehae/crud_project/internal/models"
+)
+
+// MemoryScheduleRepository is a ScheduleStore kept in process memory. It
+// only serves a single process, so claiming needs no locking beyond its own
+// mutex. It takes no part in the transactions of a TxManager: a claim marks
+// nothing, and SaveScheduleRun, which the worker calls last, re-reads the
+// schedule so that a cancel made while the occurrence ran is kept.
+type MemoryScheduleRepository struct {
+	mu        sync.Mutex
+	schedules []models.ScheduledTransfer
+	runs      []models.ScheduledTransferRun
+}
+
+var _ ScheduleStore = (*MemoryScheduleRepository)(nil)
+
+func NewMemoryScheduleRepository() *MemoryScheduleRepository {
+	return &MemoryScheduleRepository{}
+}
+
+func (r *MemoryScheduleRepository) CreateSchedule(ctx context.Context, st models.ScheduledTransfer) (*models.ScheduledTransfer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	st.Id = int64(len(r.schedules) + 1)
+	st.CreatedAt, st.UpdatedAt = now, now
+	r.schedules = append(r.schedules, st)
+	return &st, nil
+}
+
+func (r *MemoryScheduleRepository) GetSchedule(ctx context.Context, id int64) (*models.ScheduledTransfer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id < 1 || id > int64(len(r.schedules)) {
+		return nil, ErrNotFound
+	}
+	st := r.schedules[id-1]
+	return &st, nil
+}
+
+func (r *MemoryScheduleRepository) CancelSchedule(ctx context.Context, id int64) (*models.ScheduledTransfer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id < 1 || id > int64(len(r.schedules)) {
+		return nil, ErrNotFound
+	}
+	st := r.schedules[id-1]
+	if st.Status != models.ScheduleStatusActive {
+		return nil, ErrScheduleNotActive
+	}
+	st.Status = models.ScheduleStatusCancelled
+	st.NextRunAt, st.RetryAt = nil, nil
+	st.UpdatedAt = time.Now().UTC()
+	r.schedules[id-1] = st
+	return &st, nil
+}
+
+func (r *MemoryScheduleRepository) ListScheduleRuns(ctx context.Context, id int64, limit int) ([]models.ScheduledTransferRun, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id < 1 || id > int64(len(r.schedules)) {
+		return nil, ErrNotFound
+	}
+	runs := []models.ScheduledTransferRun{}
+	for i := len(r.runs) - 1; i >= 0 && len(runs) < limit; i-- {
+		if r.runs[i].ScheduleId == id {
+			runs = append(runs, r.runs[i])
+		}
+	}
+	return runs, nil
+}
+
+// ClaimDueSchedule mirrors ScheduleRepository.ClaimDueSchedule.
+func (r *MemoryScheduleRepository) ClaimDueSchedule(ctx context.Context, now time.Time) (*models.ScheduledTransfer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var due []models.ScheduledTransfer
+	for _, st := range r.schedules {
+		if st.Status == models.ScheduleStatusActive && !dueAt(st).After(now) {
+			due = append(due, st)
+		}
+	}
+	if len(due) == 0 {
+		return nil, ErrNotFound
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if a, b := dueAt(due[i]), dueAt(due[j]); !a.Equal(b) {
+			return a.Before(b)
+		}
+		return due[i].Id < due[j].Id
+	})
+	return &due[0], nil
+}
+
+func dueAt(st models.ScheduledTransfer) time.Time {
+	if st.RetryAt != nil {
+		return *st.RetryAt
+	}
+	return *st.NextRunAt
+}
+
+func (r *MemoryScheduleRepository) SaveScheduleRun(ctx context.Context, st *models.ScheduledTransfer, run *models.ScheduledTransferRun) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if st.Id < 1 || st.Id > int64(len(r.schedules)) {
+		return ErrNotFound
+	}
+	now := time.Now().UTC()
+	run.Id = int64(len(r.runs) + 1)
+	run.ScheduleId = st.Id
+	run.CreatedAt = now
+	r.runs = append(r.runs, *run)
+
+	// the occurrence has run either way, but a schedule cancelled meanwhile
+	// stays cancelled instead of being scheduled again
+	if stored := r.schedules[st.Id-1]; stored.Status != models.ScheduleStatusActive {
+		*st = stored
+		return nil
+	}
+	st.UpdatedAt = now
+	r.schedules[st.Id-1] = *st
+	return nil
+}
@@ -0,0 +1,811 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lahaehae/crud_project/internal/currency"
+	"github.com/lahaehae/crud_project/internal/models"
+)
+
+// MemoryUserRepository is a UserRepo kept in process memory. It returns the
+// same domain errors as UserRepository and is meant for demos and tests; all
+// data is lost when the process exits.
+//
+// It is also its own TxManager: a transaction holds the write lock for its
+// whole duration, so transactions are serializable. Every change made in a
+// transaction is journaled with how to undo it, and a failed transaction or
+// savepoint undoes its part of the journal, so rolling back costs as much as
+// the changes made, whatever the size of the data.
+type MemoryUserRepository struct {
+	mu        sync.RWMutex
+	users     map[int64]models.User
+	transfers []models.Transfer
+	ledger    []memoryLedgerTx
+	holds     []models.Hold
+	limits    map[int64]models.Limits
+	lastID    int64
+
+	// undo journals the changes of the transaction in progress, if any
+	undo []func()
+	txs  int
+}
+
+// memoryLedgerTx is a ledger transaction together with its entries.
+type memoryLedgerTx struct {
+	Kind       string
+	TransferId *int64
+	Entries    []ledgerEntry
+	CreatedAt  time.Time
+}
+
+var (
+	_ UserRepo  = (*MemoryUserRepository)(nil)
+	_ TxManager = (*MemoryUserRepository)(nil)
+)
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: map[int64]models.User{}, limits: map[int64]models.Limits{}}
+}
+
+func (r *MemoryUserRepository) CreateUser(ctx context.Context, name, email string, cur currency.Currency, balance int64) (*models.User, error) {
+	defer r.lock(ctx)()
+
+	if err := r.checkUser(0, email, balance); err != nil {
+		return nil, err
+	}
+	lastID := r.lastID
+	r.journal(func() { r.lastID = lastID })
+	r.lastID++
+	user := models.User{Id: r.lastID, Name: name, Email: email, Currency: cur.Code, CurrencyExponent: cur.Exponent, Version: 1}
+	setKey(r, r.users, user.Id, user)
+	if balance != 0 {
+		r.post(LedgerDeposit, nil, userEntry(user.Id, cur.Code, balance), systemEntry(SystemAccountCash, cur.Code, -balance))
+		user = r.users[user.Id]
+	}
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) GetUser(ctx context.Context, id int64) (*models.User, error) {
+	defer r.rlock(ctx)()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	defer r.rlock(ctx)()
+
+	for _, user := range r.users {
+		if strings.EqualFold(user.Email, email) {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// ListUsers mirrors UserRepository.ListUsers, including its cursors.
+func (r *MemoryUserRepository) ListUsers(ctx context.Context, filter models.UserFilter) (*models.UserPage, error) {
+	sortBy := filter.SortBy
+	if sortBy == "" {
+		sortBy = models.UserSortByID
+	}
+	// compare orders a before b on (sort column, id)
+	var compare func(a, b models.User) int
+	switch sortBy {
+	case models.UserSortByID:
+		compare = func(a, b models.User) int { return cmpInt(a.Id, b.Id) }
+	case models.UserSortByName:
+		compare = func(a, b models.User) int {
+			if c := strings.Compare(a.Name, b.Name); c != 0 {
+				return c
+			}
+			return cmpInt(a.Id, b.Id)
+		}
+	case models.UserSortByBalance:
+		compare = func(a, b models.User) int {
+			if c := cmpInt(a.Balance, b.Balance); c != 0 {
+				return c
+			}
+			return cmpInt(a.Id, b.Id)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported sort column %q", sortBy)
+	}
+	if filter.Desc {
+		asc := compare
+		compare = func(a, b models.User) int { return -asc(a, b) }
+	}
+
+	var after *models.User
+	if filter.Cursor != "" {
+		var cur userCursor
+		if err := decodeCursor(filter.Cursor, &cur); err != nil {
+			return nil, err
+		}
+		if cur.SortBy != sortBy {
+			return nil, fmt.Errorf("%w: cursor was issued for sort %q", ErrInvalidCursor, cur.SortBy)
+		}
+		after = &models.User{Id: cur.Id, Name: cur.Name, Balance: cur.Balance}
+	}
+
+	unlock := r.rlock(ctx)
+	users := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		if matchesUserFilter(user, filter) && (after == nil || compare(user, *after) > 0) {
+			users = append(users, user)
+		}
+	}
+	unlock()
+
+	sort.Slice(users, func(i, j int) bool { return compare(users[i], users[j]) < 0 })
+
+	page := &models.UserPage{Users: users}
+	if len(users) > filter.Limit {
+		page.Users = users[:filter.Limit]
+		last := page.Users[len(page.Users)-1]
+		page.NextCursor = encodeCursor(userCursor{
+			SortBy:  sortBy,
+			Name:    last.Name,
+			Balance: last.Balance,
+			Id:      last.Id,
+		})
+	}
+	return page, nil
+}
+
+func matchesUserFilter(user models.User, filter models.UserFilter) bool {
+	contains := func(s, substr string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+	}
+	switch {
+	case filter.Name != "" && !contains(user.Name, filter.Name):
+		return false
+	case filter.Email != "" && !contains(user.Email, filter.Email):
+		return false
+	case filter.MinBalance != nil && user.Balance < *filter.MinBalance:
+		return false
+	case filter.MaxBalance != nil && user.Balance > *filter.MaxBalance:
+		return false
+	}
+	return true
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (r *MemoryUserRepository) UpdateUser(ctx context.Context, id int64, name, email string, version int64) (*models.User, error) {
+	defer r.lock(ctx)()
+
+	user, err := r.lookup(id, version)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkUser(id, email, user.Balance); err != nil {
+		return nil, err
+	}
+	user.Name, user.Email = name, email
+	user.Version++
+	setKey(r, r.users, id, user)
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) PatchUser(ctx context.Context, id int64, patch models.UserPatch, version int64) (*models.User, error) {
+	defer r.lock(ctx)()
+
+	user, err := r.lookup(id, version)
+	if err != nil {
+		return nil, err
+	}
+	if patch.Name != nil {
+		user.Name = *patch.Name
+	}
+	if patch.Email != nil {
+		user.Email = *patch.Email
+	}
+	if err := r.checkUser(id, user.Email, user.Balance); err != nil {
+		return nil, err
+	}
+	user.Version++
+	setKey(r, r.users, id, user)
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) DeleteUser(ctx context.Context, id int64, version int64) error {
+	defer r.lock(ctx)()
+
+	user, err := r.lookup(id, version)
+	if err != nil {
+		return err
+	}
+	if held := user.Balance - user.AvailableBalance; user.Balance != 0 || held != 0 {
+		return &AccountInUseError{AccountID: id, Balance: user.Balance, Held: held}
+	}
+	if r.hasHistory(id) {
+		return &AccountInUseError{AccountID: id}
+	}
+	deleteKey(r, r.users, id)
+	deleteKey(r, r.limits, id)
+	return nil
+}
+
+// hasHistory reports whether the ledger, a transfer or a hold references
+// the account id, as the foreign keys of the users table would. The caller
+// must hold r.mu.
+func (r *MemoryUserRepository) hasHistory(id int64) bool {
+	for _, tx := range r.ledger {
+		for _, e := range tx.Entries {
+			if e.SystemAccount == "" && e.UserId == id {
+				return true
+			}
+		}
+	}
+	for _, t := range r.transfers {
+		if t.FromId == id || t.ToId == id {
+			return true
+		}
+	}
+	for _, h := range r.holds {
+		if h.UserId == id || h.ToId == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryUserRepository) TransferFunds(ctx context.Context, fromId, toId, balance int64, currency string) (*models.Transfer, error) {
+	defer r.lock(ctx)()
+
+	transfer := models.Transfer{
+		FromId:   fromId,
+		ToId:     toId,
+		Amount:   balance,
+		Currency: currency,
+		Status:   models.TransferStatusCompleted,
+	}
+	if err := r.transfer(&transfer); err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+func (r *MemoryUserRepository) ExchangeFunds(ctx context.Context, fromId, toId, amount int64, quote currency.Quote) (*models.Transfer, error) {
+	transfer, err := exchange(fromId, toId, amount, quote)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.lock(ctx)()
+
+	if err := r.transfer(&transfer); err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+// TransferBatch mirrors UserRepository.TransferBatch. A failed order leaves
+// no trace, so best effort needs no savepoints here.
+func (r *MemoryUserRepository) TransferBatch(ctx context.Context, orders []BatchOrder, atomic bool, limits BatchLimiter) ([]BatchResult, error) {
+	var results []BatchResult
+	err := r.WithinTx(ctx, func(ctx context.Context) error {
+		results = make([]BatchResult, len(orders))
+		for i, o := range orders {
+			var err error
+			if limits != nil {
+				err = limits.Check(ctx, o)
+			}
+			var transfer models.Transfer
+			if err == nil {
+				transfer, err = o.plan()
+			}
+			if err == nil {
+				err = r.transfer(&transfer)
+			}
+			if err != nil {
+				if atomic {
+					return &BatchItemError{Index: i, Err: err}
+				}
+				results[i].Err = err
+				continue
+			}
+			if limits != nil {
+				limits.Count(o)
+			}
+			results[i].Transfer = &transfer
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// transfer validates t before changing anything, so that a failed transfer
+// leaves no trace, like a rolled back transaction, then records and books
+// it. The caller must hold r.mu.
+func (r *MemoryUserRepository) transfer(t *models.Transfer) error {
+	if t.Amount <= 0 {
+		return fmt.Errorf("%w: amount must be positive", ErrValidation)
+	}
+	if err := checkTransfer(r.accounts(t.FromId, t.ToId), t); err != nil {
+		return err
+	}
+
+	t.Id = int64(len(r.transfers) + 1)
+	t.CreatedAt = time.Now().UTC()
+	appendTo(r, &r.transfers, *t)
+	r.post(LedgerTransfer, &t.Id, transferEntries(t)...)
+	return nil
+}
+
+func (r *MemoryUserRepository) GetTransfer(ctx context.Context, id int64) (*models.Transfer, error) {
+	defer r.rlock(ctx)()
+
+	if id < 1 || id > int64(len(r.transfers)) {
+		return nil, ErrNotFound
+	}
+	transfer := r.transfers[id-1]
+	return &transfer, nil
+}
+
+// ListTransfers mirrors UserRepository.ListTransfers, including its cursors.
+func (r *MemoryUserRepository) ListTransfers(ctx context.Context, filter models.TransferFilter) (*models.TransferPage, error) {
+	var after *transferCursor
+	if filter.Cursor != "" {
+		after = &transferCursor{}
+		if err := decodeCursor(filter.Cursor, after); err != nil {
+			return nil, err
+		}
+	}
+
+	defer r.rlock(ctx)()
+
+	if _, ok := r.users[filter.UserId]; !ok {
+		return nil, &AccountNotFoundError{AccountID: filter.UserId}
+	}
+
+	page := &models.TransferPage{Transfers: []models.Transfer{}}
+	// transfers are appended in creation order, so walk them backwards
+	for i := len(r.transfers) - 1; i >= 0 && len(page.Transfers) <= filter.Limit; i-- {
+		t := r.transfers[i]
+		if matchesTransferFilter(t, filter) &&
+			(after == nil || t.CreatedAt.Before(after.CreatedAt) || t.CreatedAt.Equal(after.CreatedAt) && t.Id < after.Id) {
+			page.Transfers = append(page.Transfers, t)
+		}
+	}
+
+	if len(page.Transfers) > filter.Limit {
+		page.Transfers = page.Transfers[:filter.Limit]
+		last := page.Transfers[len(page.Transfers)-1]
+		page.NextCursor = encodeCursor(transferCursor{CreatedAt: last.CreatedAt, Id: last.Id})
+	}
+	return page, nil
+}
+
+func matchesTransferFilter(t models.Transfer, filter models.TransferFilter) bool {
+	switch filter.Direction {
+	case models.TransferDirectionOut:
+		if t.FromId != filter.UserId {
+			return false
+		}
+	case models.TransferDirectionIn:
+		if t.ToId != filter.UserId {
+			return false
+		}
+	default:
+		if t.FromId != filter.UserId && t.ToId != filter.UserId {
+			return false
+		}
+	}
+	switch {
+	case filter.Since != nil && t.CreatedAt.Before(*filter.Since):
+		return false
+	case filter.Until != nil && !t.CreatedAt.Before(*filter.Until):
+		return false
+	case filter.MinAmount != nil && t.Amount < *filter.MinAmount:
+		return false
+	case filter.MaxAmount != nil && t.Amount > *filter.MaxAmount:
+		return false
+	}
+	return true
+}
+
+func (r *MemoryUserRepository) ReverseTransfer(ctx context.Context, id, amount int64) (*models.Transfer, error) {
+	var reversal *models.Transfer
+	err := r.WithinTx(ctx, func(ctx context.Context) error {
+		if id < 1 || id > int64(len(r.transfers)) {
+			return ErrNotFound
+		}
+		original := r.transfers[id-1]
+		planned, err := PlanReversal(&original, amount)
+		if err != nil {
+			return err
+		}
+		if err := r.transfer(&planned); err != nil {
+			return err
+		}
+		reversal = &planned
+		setIndex(r, &r.transfers, int(id-1), original)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reversal, nil
+}
+
+func (r *MemoryUserRepository) Deposit(ctx context.Context, id, amount int64) (*models.User, error) {
+	return r.cashMovement(ctx, LedgerDeposit, id, amount)
+}
+
+func (r *MemoryUserRepository) Withdraw(ctx context.Context, id, amount int64) (*models.User, error) {
+	return r.cashMovement(ctx, LedgerWithdrawal, id, -amount)
+}
+
+func (r *MemoryUserRepository) cashMovement(ctx context.Context, kind string, id, delta int64) (*models.User, error) {
+	defer r.lock(ctx)()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, &AccountNotFoundError{AccountID: id}
+	}
+	if user.AvailableBalance+delta < 0 {
+		return nil, &InsufficientFundsError{AccountID: id, Balance: user.AvailableBalance, Amount: -delta}
+	}
+	r.post(kind, nil, userEntry(id, user.Currency, delta), systemEntry(SystemAccountCash, user.Currency, -delta))
+	user = r.users[id]
+	return &user, nil
+}
+
+// post records a ledger transaction and applies it to the balances, like
+// UserRepository.post. The caller must hold r.mu and have checked the
+// accounts; unbalanced entries are a programming error.
+func (r *MemoryUserRepository) post(kind string, transferId *int64, entries ...ledgerEntry) {
+	if err := checkBalanced(entries); err != nil {
+		panic(err)
+	}
+	appendTo(r, &r.ledger, memoryLedgerTx{Kind: kind, TransferId: transferId, Entries: entries, CreatedAt: time.Now().UTC()})
+	for _, e := range entries {
+		if e.SystemAccount != "" {
+			continue
+		}
+		user := r.users[e.UserId]
+		user.Balance += e.Amount
+		user.AvailableBalance += e.Amount
+		user.Version++
+		setKey(r, r.users, e.UserId, user)
+	}
+}
+
+func (r *MemoryUserRepository) CreateHold(ctx context.Context, userId, toId, amount int64, currency string, expiresAt time.Time) (*models.Hold, error) {
+	defer r.lock(ctx)()
+
+	if err := checkHold(r.accounts(userId, toId), userId, toId, amount, currency); err != nil {
+		return nil, err
+	}
+	r.reserve(userId, amount)
+	now := time.Now().UTC()
+	hold := models.Hold{
+		Id:        int64(len(r.holds) + 1),
+		UserId:    userId,
+		ToId:      toId,
+		Amount:    amount,
+		Currency:  currency,
+		Status:    models.HoldStatusActive,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	appendTo(r, &r.holds, hold)
+	return &hold, nil
+}
+
+func (r *MemoryUserRepository) GetHold(ctx context.Context, id int64) (*models.Hold, error) {
+	defer r.rlock(ctx)()
+
+	if id < 1 || id > int64(len(r.holds)) {
+		return nil, ErrNotFound
+	}
+	hold := r.holds[id-1]
+	return &hold, nil
+}
+
+// LockHold reads hold id; transactions never overlap, so there is nothing
+// to lock.
+func (r *MemoryUserRepository) LockHold(ctx context.Context, id int64) (*models.Hold, error) {
+	return r.GetHold(ctx, id)
+}
+
+func (r *MemoryUserRepository) CaptureHold(ctx context.Context, id, amount int64, quote *currency.Quote) (*models.Transfer, error) {
+	var transfer models.Transfer
+	err := r.WithinTx(ctx, func(ctx context.Context) error {
+		if id < 1 || id > int64(len(r.holds)) {
+			return ErrNotFound
+		}
+		hold := r.holds[id-1]
+		captured, err := planCapture(&hold, amount, time.Now())
+		if err != nil {
+			return err
+		}
+		if transfer, err = holdTransfer(&hold, captured, quote); err != nil {
+			return err
+		}
+		if _, ok := r.users[hold.UserId]; !ok {
+			return &AccountNotFoundError{AccountID: hold.UserId}
+		}
+		r.reserve(hold.UserId, -hold.Amount)
+		if err := r.transfer(&transfer); err != nil {
+			return err
+		}
+		hold.Status = models.HoldStatusCaptured
+		hold.CapturedAmount = captured
+		hold.TransferId = &transfer.Id
+		hold.UpdatedAt = time.Now().UTC()
+		setIndex(r, &r.holds, int(id-1), hold)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+func (r *MemoryUserRepository) VoidHold(ctx context.Context, id int64) (*models.Hold, error) {
+	defer r.lock(ctx)()
+
+	if id < 1 || id > int64(len(r.holds)) {
+		return nil, ErrNotFound
+	}
+	hold := r.holds[id-1]
+	if err := checkActive(&hold, time.Now()); err != nil {
+		return nil, err
+	}
+	if _, ok := r.users[hold.UserId]; !ok {
+		return nil, &AccountNotFoundError{AccountID: hold.UserId}
+	}
+	r.reserve(hold.UserId, -hold.Amount)
+	hold.Status = models.HoldStatusVoided
+	hold.UpdatedAt = time.Now().UTC()
+	setIndex(r, &r.holds, int(id-1), hold)
+	return &hold, nil
+}
+
+func (r *MemoryUserRepository) ExpireHolds(ctx context.Context) (int64, error) {
+	defer r.lock(ctx)()
+
+	var n int64
+	now := time.Now().UTC()
+	for i, hold := range r.holds {
+		if hold.Status != models.HoldStatusActive || now.Before(hold.ExpiresAt) {
+			continue
+		}
+		if _, ok := r.users[hold.UserId]; ok {
+			r.reserve(hold.UserId, -hold.Amount)
+		}
+		hold.Status = models.HoldStatusExpired
+		hold.UpdatedAt = now
+		setIndex(r, &r.holds, i, hold)
+		n++
+	}
+	return n, nil
+}
+
+// reserve moves amount of a user's balance out of the available balance, or
+// back into it when amount is negative. The caller must hold r.mu.
+func (r *MemoryUserRepository) reserve(id, amount int64) {
+	user := r.users[id]
+	user.AvailableBalance -= amount
+	user.Version++
+	setKey(r, r.users, id, user)
+}
+
+// accounts returns what UserRepository.lockAccounts would for ids. The
+// caller must hold r.mu.
+func (r *MemoryUserRepository) accounts(ids ...int64) map[int64]account {
+	accounts := map[int64]account{}
+	for _, id := range ids {
+		if user, ok := r.users[id]; ok {
+			accounts[id] = account{
+				Balance:  user.Balance,
+				Held:     user.Balance - user.AvailableBalance,
+				Currency: user.Currency,
+			}
+		}
+	}
+	return accounts
+}
+
+// lookup returns the user with id, enforcing version like the conditional
+// writes of UserRepository. The caller must hold r.mu.
+func (r *MemoryUserRepository) lookup(id, version int64) (models.User, error) {
+	user, ok := r.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	if version != 0 && user.Version != version {
+		return models.User{}, ErrVersionMismatch
+	}
+	return user, nil
+}
+
+// checkUser enforces the constraints the users table declares. The caller
+// must hold r.mu.
+func (r *MemoryUserRepository) checkUser(id int64, email string, balance int64) error {
+	if balance < 0 {
+		return ErrInsufficientFunds
+	}
+	for _, other := range r.users {
+		if other.Id != id && strings.EqualFold(other.Email, email) {
+			return &ConflictError{Field: "email", Constraint: "users_email_lower_key"}
+		}
+	}
+	return nil
+}
+
+type memoryTxKey struct{}
+
+// inTx reports whether ctx belongs to a transaction of r, which already
+// holds r.mu.
+func (r *MemoryUserRepository) inTx(ctx context.Context) bool {
+	owner, _ := ctx.Value(memoryTxKey{}).(*MemoryUserRepository)
+	return owner == r
+}
+
+func (r *MemoryUserRepository) lock(ctx context.Context) (unlock func()) {
+	if r.inTx(ctx) {
+		return func() {}
+	}
+	r.mu.Lock()
+	return r.mu.Unlock
+}
+
+func (r *MemoryUserRepository) rlock(ctx context.Context) (unlock func()) {
+	if r.inTx(ctx) {
+		return func() {}
+	}
+	r.mu.RLock()
+	return r.mu.RUnlock
+}
+
+// LockAccounts has nothing to do: transactions never overlap.
+func (r *MemoryUserRepository) LockAccounts(ctx context.Context, ids ...int64) error {
+	return nil
+}
+
+// TransferUsage mirrors UserRepository.TransferUsage.
+func (r *MemoryUserRepository) TransferUsage(ctx context.Context, userId int64, now time.Time) (*models.TransferUsage, error) {
+	defer r.rlock(ctx)()
+
+	day, month, hour := usageWindows(now)
+	var usage models.TransferUsage
+	for _, t := range r.transfers {
+		if t.FromId != userId {
+			continue
+		}
+		if !t.CreatedAt.Before(day) {
+			usage.DayAmount += t.Amount
+		}
+		if !t.CreatedAt.Before(month) {
+			usage.MonthAmount += t.Amount
+		}
+		if !t.CreatedAt.Before(hour) {
+			usage.HourCount++
+		}
+	}
+	return &usage, nil
+}
+
+func (r *MemoryUserRepository) GetLimits(ctx context.Context, userId int64) (*models.Limits, error) {
+	defer r.rlock(ctx)()
+
+	if _, ok := r.users[userId]; !ok {
+		return nil, ErrNotFound
+	}
+	limits := r.limits[userId]
+	return &limits, nil
+}
+
+func (r *MemoryUserRepository) SetLimits(ctx context.Context, userId int64, limits models.Limits) (*models.Limits, error) {
+	defer r.lock(ctx)()
+
+	if _, ok := r.users[userId]; !ok {
+		return nil, ErrNotFound
+	}
+	setKey(r, r.limits, userId, limits)
+	return &limits, nil
+}
+
+// WithinTx ignores the isolation option: transactions never overlap. A
+// nested call is a savepoint: its failure undoes only its own changes.
+func (r *MemoryUserRepository) WithinTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) (err error) {
+	if !r.inTx(ctx) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		ctx = context.WithValue(ctx, memoryTxKey{}, r)
+	}
+
+	r.txs++
+	mark := len(r.undo)
+	defer func() {
+		r.txs--
+		if p := recover(); p != nil {
+			r.rollback(mark)
+			panic(p)
+		}
+		if err != nil {
+			r.rollback(mark)
+		}
+		if r.txs == 0 {
+			r.undo = nil
+		}
+	}()
+	return fn(ctx)
+}
+
+// rollback undoes the changes journaled since mark, newest first. The
+// caller must hold r.mu.
+func (r *MemoryUserRepository) rollback(mark int) {
+	for i := len(r.undo) - 1; i >= mark; i-- {
+		r.undo[i]()
+	}
+	r.undo = r.undo[:mark]
+}
+
+// journal records how to undo a change when a transaction is in progress;
+// outside one there is nothing to roll back. The caller must hold r.mu.
+func (r *MemoryUserRepository) journal(undo func()) {
+	if r.txs > 0 {
+		r.undo = append(r.undo, undo)
+	}
+}
+
+// setKey stores v under key in m, a map of r, journaling the entry it
+// replaces.
+func setKey[K comparable, V any](r *MemoryUserRepository, m map[K]V, key K, v V) {
+	old, ok := m[key]
+	r.journal(func() {
+		if ok {
+			m[key] = old
+		} else {
+			delete(m, key)
+		}
+	})
+	m[key] = v
+}
+
+// deleteKey removes key from m, a map of r, journaling the entry.
+func deleteKey[K comparable, V any](r *MemoryUserRepository, m map[K]V, key K) {
+	if old, ok := m[key]; ok {
+		r.journal(func() { m[key] = old })
+		delete(m, key)
+	}
+}
+
+// setIndex replaces element i of *s, a slice of r, journaling the old one.
+func setIndex[T any](r *MemoryUserRepository, s *[]T, i int, v T) {
+	old := (*s)[i]
+	r.journal(func() { (*s)[i] = old })
+	(*s)[i] = v
+}
+
+// appendTo appends v to *s, a slice of r, journaling the append.
+func appendTo[T any](r *MemoryUserRepository, s *[]T, v T) {
+	n := len(*s)
+	r.journal(func() { *s = (*s)[:n] })
+	*s = append(*s, v)
+}
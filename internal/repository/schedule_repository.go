@@ -0,0 +1,244 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScheduleStore keeps scheduled transfers and the outcomes of their runs.
+// ClaimDueSchedule and SaveScheduleRun must be called inside a transaction
+// of the TxManager for the same store.
+type ScheduleStore interface {
+	CreateSchedule(ctx context.Context, st models.ScheduledTransfer) (*models.ScheduledTransfer, error)
+	GetSchedule(ctx context.Context, id int64) (*models.ScheduledTransfer, error)
+	CancelSchedule(ctx context.Context, id int64) (*models.ScheduledTransfer, error)
+	ListScheduleRuns(ctx context.Context, id int64, limit int) ([]models.ScheduledTransferRun, error)
+	ClaimDueSchedule(ctx context.Context, now time.Time) (*models.ScheduledTransfer, error)
+	SaveScheduleRun(ctx context.Context, st *models.ScheduledTransfer, run *models.ScheduledTransferRun) error
+}
+
+// ScheduleRepository is the Postgres ScheduleStore. Its methods join the
+// transaction carried by ctx, see TxManager.
+type ScheduleRepository struct {
+	db     *pgxpool.Pool
+	tracer trace.Tracer
+}
+
+var _ ScheduleStore = (*ScheduleRepository)(nil)
+
+func NewScheduleRepository(db *pgxpool.Pool) *ScheduleRepository {
+	return &ScheduleRepository{
+		db:     db,
+		tracer: otel.Tracer("repository"),
+	}
+}
+
+func (r *ScheduleRepository) conn(ctx context.Context) querier {
+	return connFor(ctx, r.db)
+}
+
+const scheduleColumns = `id, from_id, to_id, amount, currency, run_at, COALESCE(cron, ''), COALESCE(repeat_interval, ''),
+	start_at, end_at, max_runs, run_count, attempt, status, next_run_at, retry_at, created_at, updated_at`
+
+func scanSchedule(row rowScanner, st *models.ScheduledTransfer) error {
+	return row.Scan(&st.Id, &st.FromId, &st.ToId, &st.Amount, &st.Currency, &st.RunAt, &st.Cron, &st.Interval,
+		&st.StartAt, &st.EndAt, &st.MaxRuns, &st.RunCount, &st.Attempt, &st.Status, &st.NextRunAt, &st.RetryAt,
+		&st.CreatedAt, &st.UpdatedAt)
+}
+
+func (r *ScheduleRepository) CreateSchedule(ctx context.Context, st models.ScheduledTransfer) (*models.ScheduledTransfer, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.CreateSchedule")
+	defer span.End()
+
+	start := time.Now()
+
+	query := `INSERT INTO scheduled_transfers
+			(from_id, to_id, amount, currency, run_at, cron, repeat_interval, start_at, end_at, max_runs, status, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8, $9, $10, $11, $12)
+		RETURNING ` + scheduleColumns
+	var created models.ScheduledTransfer
+	err := scanSchedule(r.conn(ctx).QueryRow(ctx, query, st.FromId, st.ToId, st.Amount, st.Currency, st.RunAt, st.Cron,
+		st.Interval, st.StartAt, st.EndAt, st.MaxRuns, st.Status, st.NextRunAt), &created)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "insert_schedule", err)
+		return nil, mapError(err)
+	}
+
+	span.SetAttributes(attribute.Int64("db_query.schedule_id", created.Id))
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return &created, nil
+}
+
+func (r *ScheduleRepository) GetSchedule(ctx context.Context, id int64) (*models.ScheduledTransfer, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.GetSchedule")
+	defer span.End()
+
+	start := time.Now()
+
+	var st models.ScheduledTransfer
+	query := "SELECT " + scheduleColumns + " FROM scheduled_transfers WHERE id = $1"
+	if err := scanSchedule(r.conn(ctx).QueryRow(ctx, query, id), &st); err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "get_schedule", err)
+		return nil, mapError(err)
+	}
+
+	span.SetAttributes(attribute.Int64("db_query.schedule_id", id))
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return &st, nil
+}
+
+// CancelSchedule stops an active schedule. A run in progress finishes first.
+func (r *ScheduleRepository) CancelSchedule(ctx context.Context, id int64) (*models.ScheduledTransfer, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.CancelSchedule")
+	defer span.End()
+
+	start := time.Now()
+
+	var st models.ScheduledTransfer
+	query := `UPDATE scheduled_transfers
+		SET status = $1, next_run_at = NULL, retry_at = NULL, updated_at = now()
+		WHERE id = $2 AND status = $3
+		RETURNING ` + scheduleColumns
+	err := scanSchedule(r.conn(ctx).QueryRow(ctx, query, models.ScheduleStatusCancelled, id, models.ScheduleStatusActive), &st)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var exists bool
+		err = r.conn(ctx).QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM scheduled_transfers WHERE id = $1)", id).Scan(&exists)
+		if err == nil {
+			err = ErrScheduleNotActive
+			if !exists {
+				err = ErrNotFound
+			}
+		}
+	}
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "cancel_schedule", err)
+		return nil, mapError(err)
+	}
+
+	span.SetAttributes(attribute.Int64("db_query.schedule_id", id))
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return &st, nil
+}
+
+// ListScheduleRuns returns the latest runs of a schedule, newest first.
+func (r *ScheduleRepository) ListScheduleRuns(ctx context.Context, id int64, limit int) ([]models.ScheduledTransferRun, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.ListScheduleRuns")
+	defer span.End()
+
+	start := time.Now()
+
+	if _, err := r.GetSchedule(ctx, id); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT id, schedule_id, scheduled_for, attempt, status, transfer_id, COALESCE(error, ''), retry_at, created_at
+		FROM scheduled_transfer_runs WHERE schedule_id = $1 ORDER BY id DESC LIMIT $2`
+	rows, err := r.conn(ctx).Query(ctx, query, id, limit)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "list_schedule_runs", err)
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	runs := []models.ScheduledTransferRun{}
+	for rows.Next() {
+		var run models.ScheduledTransferRun
+		err := rows.Scan(&run.Id, &run.ScheduleId, &run.ScheduledFor, &run.Attempt, &run.Status, &run.TransferId,
+			&run.Error, &run.RetryAt, &run.CreatedAt)
+		if err != nil {
+			span.RecordError(err)
+			telemetry.RecordErrorMetric(ctx, "scan_schedule_run", err)
+			return nil, mapError(err)
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "list_schedule_runs", err)
+		return nil, mapError(err)
+	}
+
+	span.SetAttributes(
+		attribute.Int64("db_query.schedule_id", id),
+		attribute.Int("db_query.rows", len(runs)),
+	)
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return runs, nil
+}
+
+// ClaimDueSchedule locks the active schedule that has been due the longest
+// and returns it, or ErrNotFound when nothing is due. Schedules locked by
+// other workers are skipped, so that replicas never run the same occurrence
+// twice; the lock is held until the caller's transaction ends.
+func (r *ScheduleRepository) ClaimDueSchedule(ctx context.Context, now time.Time) (*models.ScheduledTransfer, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.ClaimDueSchedule")
+	defer span.End()
+
+	var st models.ScheduledTransfer
+	query := "SELECT " + scheduleColumns + ` FROM scheduled_transfers
+		WHERE status = $1 AND COALESCE(retry_at, next_run_at) <= $2
+		ORDER BY COALESCE(retry_at, next_run_at), id
+		LIMIT 1 FOR UPDATE SKIP LOCKED`
+	if err := scanSchedule(r.conn(ctx).QueryRow(ctx, query, models.ScheduleStatusActive, now), &st); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			span.RecordError(err)
+			telemetry.RecordErrorMetric(ctx, "claim_schedule", err)
+		}
+		return nil, mapError(err)
+	}
+	span.SetAttributes(attribute.Int64("db_query.schedule_id", st.Id))
+	return &st, nil
+}
+
+// SaveScheduleRun records run and the state st has moved to because of it.
+func (r *ScheduleRepository) SaveScheduleRun(ctx context.Context, st *models.ScheduledTransfer, run *models.ScheduledTransferRun) error {
+	ctx, span := r.tracer.Start(ctx, "Repository.SaveScheduleRun")
+	defer span.End()
+
+	query := `INSERT INTO scheduled_transfer_runs (schedule_id, scheduled_for, attempt, status, transfer_id, error, retry_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7) RETURNING id, created_at`
+	err := r.conn(ctx).QueryRow(ctx, query, st.Id, run.ScheduledFor, run.Attempt, run.Status, run.TransferId, run.Error, run.RetryAt).
+		Scan(&run.Id, &run.CreatedAt)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "insert_schedule_run", err)
+		return mapError(err)
+	}
+	run.ScheduleId = st.Id
+
+	query = `UPDATE scheduled_transfers
+		SET run_count = $1, attempt = $2, status = $3, next_run_at = $4, retry_at = $5, updated_at = now()
+		WHERE id = $6 RETURNING updated_at`
+	err = r.conn(ctx).QueryRow(ctx, query, st.RunCount, st.Attempt, st.Status, st.NextRunAt, st.RetryAt, st.Id).Scan(&st.UpdatedAt)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "update_schedule", err)
+		return mapError(err)
+	}
+	span.SetAttributes(
+		attribute.Int64("db_query.schedule_id", st.Id),
+		attribute.String("schedule.run_status", run.Status),
+	)
+	return nil
+}
@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lahaehae/crud_project/internal/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TxManager runs a unit of work atomically. Repository calls made with the
+// context passed to fn take part in the transaction; fn's error or panic
+// rolls everything back. A nested WithinTx becomes a savepoint of the
+// enclosing transaction.
+//
+// fn may be called more than once: a transaction that fails because of a
+// serialization failure or deadlock is retried from the start, so fn must
+// not have side effects outside the transaction. Savepoints are not retried
+// on their own; such a failure in one is returned unwrapped, and the caller
+// must pass it on for the outermost WithinTx to retry, see Retryable.
+type TxManager interface {
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error
+}
+
+type txOptions struct {
+	isoLevel pgx.TxIsoLevel
+}
+
+type TxOption func(*txOptions)
+
+// WithIsolation overrides the manager's isolation level for one
+// transaction. It has no effect on nested calls, which inherit the level of
+// the outermost transaction.
+func WithIsolation(level pgx.TxIsoLevel) TxOption {
+	return func(o *txOptions) { o.isoLevel = level }
+}
+
+// ParseIsolation accepts the SQL names of the isolation levels, with spaces
+// or underscores.
+func ParseIsolation(s string) (pgx.TxIsoLevel, error) {
+	switch s {
+	case "read committed", "read_committed":
+		return pgx.ReadCommitted, nil
+	case "repeatable read", "repeatable_read":
+		return pgx.RepeatableRead, nil
+	case "serializable":
+		return pgx.Serializable, nil
+	}
+	return "", fmt.Errorf("unknown isolation level %q", s)
+}
+
+// querier is what the pool and a transaction have in common.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type txKey struct{}
+
+// txFromContext returns the transaction started by WithinTx, if any.
+func txFromContext(ctx context.Context) (pgx.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(pgx.Tx)
+	return tx, ok
+}
+
+// connFor returns the transaction carried by ctx, or db outside of one.
+func connFor(ctx context.Context, db *pgxpool.Pool) querier {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx
+	}
+	return db
+}
+
+// RetryPolicy bounds how a transaction is retried after a serialization
+// failure (40001) or deadlock (40P01). The wait before attempt n is drawn
+// uniformly from [0, min(MaxDelay, BaseDelay * 2^(n-2))]. There is no default
+// policy: the one built from the configuration is shared by every
+// transaction, including those the repository opens itself.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.MaxDelay
+	if shift := attempt - 2; shift < 30 {
+		d = min(d, p.BaseDelay<<shift)
+	}
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d + 1)
+}
+
+// retryableCode returns the SQLSTATE of err when re-running the transaction
+// may succeed.
+func retryableCode(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected) {
+		return pgErr.Code, true
+	}
+	return "", false
+}
+
+// Retryable reports whether err is a serialization failure or deadlock.
+// Only re-running the whole transaction may get past one, so code that
+// handles errors inside a transaction, of a savepoint in particular, must
+// return it to the outermost WithinTx rather than recover from it.
+func Retryable(err error) bool {
+	_, ok := retryableCode(err)
+	return ok
+}
+
+type PgTxManager struct {
+	db       *pgxpool.Pool
+	isoLevel pgx.TxIsoLevel
+	retry    RetryPolicy
+	tracer   trace.Tracer
+}
+
+var _ TxManager = (*PgTxManager)(nil)
+
+// NewTxManager creates a TxManager whose transactions run at isoLevel unless
+// WithIsolation says otherwise; an empty level means the server default.
+func NewTxManager(db *pgxpool.Pool, isoLevel pgx.TxIsoLevel, retry RetryPolicy) *PgTxManager {
+	if retry.MaxAttempts < 1 {
+		retry.MaxAttempts = 1
+	}
+	return &PgTxManager{
+		db:       db,
+		isoLevel: isoLevel,
+		retry:    retry,
+		tracer:   otel.Tracer("repository"),
+	}
+}
+
+func (m *PgTxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	o := txOptions{isoLevel: m.isoLevel}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ctx, span := m.tracer.Start(ctx, "Repository.WithinTx")
+	defer span.End()
+
+	outer, nested := txFromContext(ctx)
+	if nested {
+		// only the outermost transaction can be re-run, so a failure that
+		// calls for it is handed up as it is, whatever fn wrapped it in
+		span.SetAttributes(attribute.Bool("db.tx.nested", true))
+		err := m.attempt(ctx, fn, func(ctx context.Context) (pgx.Tx, error) { return outer.Begin(ctx) })
+		var pgErr *pgconn.PgError
+		if Retryable(err) && errors.As(err, &pgErr) {
+			return pgErr
+		}
+		return err
+	}
+	span.SetAttributes(attribute.String("db.tx.isolation", string(o.isoLevel)))
+	begin := func(ctx context.Context) (pgx.Tx, error) {
+		return m.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: o.isoLevel})
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := m.attempt(ctx, fn, begin)
+		code, retryable := retryableCode(err)
+		if !retryable || attempt >= m.retry.MaxAttempts {
+			span.SetAttributes(attribute.Int("db.tx.attempts", attempt))
+			return err
+		}
+
+		if telemetry.TxRetryCounter != nil {
+			telemetry.TxRetryCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("db.sqlstate", code)))
+		}
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.Int("db.tx.attempt", attempt),
+			attribute.String("db.sqlstate", code),
+		))
+		timer := time.NewTimer(m.retry.delay(attempt + 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt runs fn once in a transaction opened by begin.
+func (m *PgTxManager) attempt(ctx context.Context, fn func(ctx context.Context) error, begin func(ctx context.Context) (pgx.Tx, error)) error {
+	span := trace.SpanFromContext(ctx)
+
+	tx, err := begin(ctx)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "begin_transaction", err)
+		return mapError(err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			// the rollback must happen even if ctx is what made fn panic
+			_ = tx.Rollback(context.WithoutCancel(ctx))
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		if rbErr := tx.Rollback(context.WithoutCancel(ctx)); rbErr != nil {
+			span.RecordError(rbErr)
+			telemetry.RecordErrorMetric(ctx, "rollback_transaction", rbErr)
+		}
+		span.RecordError(err)
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "commit_transaction", err)
+		return mapError(err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/lahaehae/crud_project/internal/currency"
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// BatchOrder is one transfer of a batch. Quote is set when the recipient
+// holds another currency than the order.
+type BatchOrder struct {
+	models.TransferOrder
+	Quote *currency.Quote
+}
+
+// plan returns the transfer that carries out o.
+func (o BatchOrder) plan() (models.Transfer, error) {
+	if o.Quote != nil {
+		return exchange(o.FromId, o.ToId, o.Amount, *o.Quote)
+	}
+	return models.Transfer{
+		FromId:   o.FromId,
+		ToId:     o.ToId,
+		Amount:   o.Amount,
+		Currency: o.Currency,
+		Status:   models.TransferStatusCompleted,
+	}, nil
+}
+
+// BatchResult is the outcome of one order of a batch: the transfer made, or
+// the error that prevented it.
+type BatchResult struct {
+	Transfer *models.Transfer
+	Err      error
+}
+
+// BatchLimiter holds the orders of a batch to their senders' limits while
+// TransferBatch makes them. Check runs before an order is made and may
+// refuse it; Count runs once the order is made, so that an order that fails
+// uses up nothing.
+type BatchLimiter interface {
+	Check(ctx context.Context, o BatchOrder) error
+	Count(o BatchOrder)
+}
+
+// batchAccounts lists every account the orders touch.
+func batchAccounts(orders []BatchOrder) []int64 {
+	ids := make([]int64, 0, 2*len(orders))
+	for _, o := range orders {
+		ids = append(ids, o.FromId, o.ToId)
+	}
+	return ids
+}
+
+// TransferBatch makes orders in a single transaction, in the order given,
+// so that a later order may spend what an earlier one credited. When atomic
+// is set the first failing order rolls back the whole batch and is returned
+// as a *BatchItemError; otherwise a failing order is only rolled back itself
+// and reported in its result. Either way a serialization failure or deadlock
+// fails the batch as it is, see Retryable. limits, if not nil, vets every
+// order in the batch's transaction.
+//
+// Every account of the batch is locked up front in id order, the order
+// single transfers lock in, so that a batch cannot deadlock with them or
+// with another batch.
+func (r *UserRepository) TransferBatch(ctx context.Context, orders []BatchOrder, atomic bool, limits BatchLimiter) ([]BatchResult, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.TransferBatch")
+	defer span.End()
+
+	start := time.Now()
+
+	var results []BatchResult
+	err := r.tx.WithinTx(ctx, func(ctx context.Context) error {
+		results = make([]BatchResult, len(orders))
+		if _, err := r.lockAccounts(ctx, batchAccounts(orders)...); err != nil {
+			return err
+		}
+		for i, o := range orders {
+			var transfer models.Transfer
+			do := func(ctx context.Context) error {
+				if limits != nil {
+					if err := limits.Check(ctx, o); err != nil {
+						return err
+					}
+				}
+				var err error
+				if transfer, err = o.plan(); err != nil {
+					return err
+				}
+				return r.transfer(ctx, &transfer)
+			}
+			var err error
+			if atomic {
+				err = do(ctx)
+			} else {
+				// a savepoint keeps a failed order from aborting the rest
+				err = r.tx.WithinTx(ctx, do)
+			}
+			switch {
+			case Retryable(err):
+				// only re-running the whole batch can get past this
+				return err
+			case err != nil && atomic:
+				return &BatchItemError{Index: i, Err: err}
+			case err != nil:
+				results[i].Err = err
+				continue
+			}
+			if limits != nil {
+				limits.Count(o)
+			}
+			results[i].Transfer = &transfer
+		}
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "transfer_batch", err)
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.Int("db_query.batch_size", len(orders)),
+		attribute.Bool("db_query.batch_atomic", atomic),
+	)
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return results, nil
+}
@@ -2,10 +2,15 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lahaehae/crud_project/internal/currency"
 	"github.com/lahaehae/crud_project/internal/models"
 	"github.com/lahaehae/crud_project/internal/telemetry"
 
@@ -18,41 +23,85 @@ import (
 )
 
 type UserRepo interface {
-    CreateUser(ctx context.Context, name, email string, balance int64) (*models.User, error)
+    CreateUser(ctx context.Context, name, email string, cur currency.Currency, balance int64) (*models.User, error)
     GetUser(ctx context.Context, id int64) (*models.User, error)
-    UpdateUser(ctx context.Context, id int64, name, email string, balance int64) (*models.User, error)
-    DeleteUser(ctx context.Context, id int64) error
-    TransferFunds(ctx context.Context, fromId, toId, balance int64) (*models.User, error)
+    GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+    ListUsers(ctx context.Context, filter models.UserFilter) (*models.UserPage, error)
+    UpdateUser(ctx context.Context, id int64, name, email string, version int64) (*models.User, error)
+    PatchUser(ctx context.Context, id int64, patch models.UserPatch, version int64) (*models.User, error)
+    DeleteUser(ctx context.Context, id int64, version int64) error
+    TransferFunds(ctx context.Context, fromId, toId, balance int64, currency string) (*models.Transfer, error)
+    ExchangeFunds(ctx context.Context, fromId, toId, amount int64, quote currency.Quote) (*models.Transfer, error)
+    TransferBatch(ctx context.Context, orders []BatchOrder, atomic bool, limits BatchLimiter) ([]BatchResult, error)
+    GetTransfer(ctx context.Context, id int64) (*models.Transfer, error)
+    ListTransfers(ctx context.Context, filter models.TransferFilter) (*models.TransferPage, error)
+    ReverseTransfer(ctx context.Context, id, amount int64) (*models.Transfer, error)
+    Deposit(ctx context.Context, id, amount int64) (*models.User, error)
+    Withdraw(ctx context.Context, id, amount int64) (*models.User, error)
+    CreateHold(ctx context.Context, userId, toId, amount int64, currency string, expiresAt time.Time) (*models.Hold, error)
+    GetHold(ctx context.Context, id int64) (*models.Hold, error)
+    LockHold(ctx context.Context, id int64) (*models.Hold, error)
+    CaptureHold(ctx context.Context, id, amount int64, quote *currency.Quote) (*models.Transfer, error)
+    VoidHold(ctx context.Context, id int64) (*models.Hold, error)
+    ExpireHolds(ctx context.Context) (int64, error)
+    LockAccounts(ctx context.Context, ids ...int64) error
+    TransferUsage(ctx context.Context, userId int64, now time.Time) (*models.TransferUsage, error)
+    GetLimits(ctx context.Context, userId int64) (*models.Limits, error)
+    SetLimits(ctx context.Context, userId int64, limits models.Limits) (*models.Limits, error)
 }
 
+// UserRepository is the Postgres UserRepo. Its methods join the transaction
+// carried by ctx, see TxManager; those that need one of their own open it
+// through tx, so it should be the TxManager the services use.
 type UserRepository struct {
 	db     *pgxpool.Pool
+	tx     TxManager
 	meter  metric.Meter
 	tracer trace.Tracer
 }
 
-func NewUserRepository(db *pgxpool.Pool) *UserRepository {
+func NewUserRepository(db *pgxpool.Pool, tx TxManager) *UserRepository {
 	return &UserRepository{
 		db:     db,
+		tx:     tx,
 		meter:  otel.Meter("repository"),
 		tracer: otel.Tracer("repository"),
 	}
 }
 
-// method CreateUser without transaction
-func (r *UserRepository) CreateUser(ctx context.Context, name, email string, balance int64) (*models.User, error) {
+func (r *UserRepository) conn(ctx context.Context) querier {
+	return connFor(ctx, r.db)
+}
+
+// CreateUser inserts a user holding cur. An initial balance is booked as a
+// deposit in the same transaction.
+func (r *UserRepository) CreateUser(ctx context.Context, name, email string, cur currency.Currency, balance int64) (*models.User, error) {
 	ctx, span := r.tracer.Start(ctx, "Repository.CreateUser")
 	defer span.End()
 
 	start := time.Now()
 
-	query := "INSERT INTO users (name, email, balance) VALUES ($1, $2, $3) RETURNING id"
-	var id int64
-	err := r.db.QueryRow(ctx, query, name, email, balance).Scan(&id)
+	query := `INSERT INTO users (name, email, balance, currency, currency_exponent)
+		VALUES ($1, $2, 0, $3, $4) RETURNING id, version`
+	user := models.User{Name: name, Email: email, Currency: cur.Code, CurrencyExponent: cur.Exponent}
+	err := r.tx.WithinTx(ctx, func(ctx context.Context) error {
+		if err := r.conn(ctx).QueryRow(ctx, query, name, email, cur.Code, cur.Exponent).Scan(&user.Id, &user.Version); err != nil {
+			return mapError(err)
+		}
+		if balance == 0 {
+			return nil
+		}
+		if err := r.post(ctx, LedgerDeposit, nil, userEntry(user.Id, cur.Code, balance), systemEntry(SystemAccountCash, cur.Code, -balance)); err != nil {
+			return err
+		}
+		user.Balance, user.AvailableBalance = balance, balance
+		user.Version++
+		return nil
+	})
 	if err != nil {
 		span.RecordError(err)
 		telemetry.ErrorCounter.Add(ctx, 1, metric.WithAttributes(
-			attribute.Int64("userId: ", id),
+			attribute.Int64("userId: ", user.Id),
 			attribute.String("error.type", fmt.Sprintf("%T", err)),
 			attribute.String("error.msg", err.Error()),
 			attribute.String("query", query),
@@ -62,18 +111,13 @@ func (r *UserRepository) CreateUser(ctx context.Context, name, email string, bal
 	duration := time.Since(start).Milliseconds()
 	span.SetAttributes(
 		attribute.Int64("db_query.time_ms", duration),
-		attribute.Int64("db_query.user_id", id),
+		attribute.Int64("db_query.user_id", user.Id),
 	)
 
 	if telemetry.RepoLatencyRecorder != nil {
 		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
 	}
-	return &models.User{
-		Id:      id,
-		Name:    name,
-		Email:   email,
-		Balance: balance,
-	}, nil	
+	return &user, nil
 }
 
 // method GetUser without transaction
@@ -84,8 +128,8 @@ func (r *UserRepository) GetUser(ctx context.Context, id int64) (*models.User, e
 	start := time.Now()
 
 	var user models.User
-	query := "SELECT id, name, email, balance FROM users WHERE id = $1"
-	err := r.db.QueryRow(ctx, query, id).Scan(&user.Id, &user.Name, &user.Email, &user.Balance)
+	query := "SELECT id, name, email, balance, balance - held, currency, currency_exponent, version FROM users WHERE id = $1"
+	err := r.conn(ctx).QueryRow(ctx, query, id).Scan(&user.Id, &user.Name, &user.Email, &user.Balance, &user.AvailableBalance, &user.Currency, &user.CurrencyExponent, &user.Version)
 	if err != nil {
 		span.RecordError(err)
 		telemetry.ErrorCounter.Add(ctx, 1, metric.WithAttributes(
@@ -94,7 +138,7 @@ func (r *UserRepository) GetUser(ctx context.Context, id int64) (*models.User, e
 			attribute.String("error.msg", err.Error()),
 			attribute.String("query", query),
 		))
-		return nil, err
+		return nil, mapError(err)
 	}
 
 	duration := time.Since(start).Milliseconds()
@@ -109,14 +153,194 @@ func (r *UserRepository) GetUser(ctx context.Context, id int64) (*models.User, e
 	return &user, nil
 }
 
-func (r *UserRepository) UpdateUser(ctx context.Context, id int64, name, email string, balance int64) (*models.User, error) {
+// GetUserByEmail looks a user up by email, ignoring case.
+func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.GetUserByEmail")
+	defer span.End()
+
+	start := time.Now()
+
+	var user models.User
+	query := "SELECT id, name, email, balance, balance - held, currency, currency_exponent, version FROM users WHERE lower(email) = lower($1)"
+	err := r.conn(ctx).QueryRow(ctx, query, email).Scan(&user.Id, &user.Name, &user.Email, &user.Balance, &user.AvailableBalance, &user.Currency, &user.CurrencyExponent, &user.Version)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.ErrorCounter.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("method:", "GetUserByEmail"),
+			attribute.String("error.type", fmt.Sprintf("%T", err)),
+			attribute.String("error.msg", err.Error()),
+			attribute.String("query", query),
+		))
+		return nil, mapError(err)
+	}
+
+	duration := time.Since(start).Milliseconds()
+	span.SetAttributes(
+		attribute.Int64("db_query.time_ms", duration),
+		attribute.Int64("db_query.user_id", user.Id),
+	)
+
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return &user, nil
+}
+
+type userCursor struct {
+	SortBy  string `json:"s"`
+	Name    string `json:"n,omitempty"`
+	Balance int64  `json:"b,omitempty"`
+	Id      int64  `json:"id"`
+}
+
+// ListUsers returns one page of users using keyset pagination on the sort
+// column with id as the tie-breaker.
+func (r *UserRepository) ListUsers(ctx context.Context, filter models.UserFilter) (*models.UserPage, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.ListUsers")
+	defer span.End()
+
+	start := time.Now()
+
+	var (
+		conds []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Name != "" {
+		conds = append(conds, "name ILIKE '%' || "+arg(escapeLike(filter.Name))+" || '%'")
+	}
+	if filter.Email != "" {
+		conds = append(conds, "email ILIKE '%' || "+arg(escapeLike(filter.Email))+" || '%'")
+	}
+	if filter.MinBalance != nil {
+		conds = append(conds, "balance >= "+arg(*filter.MinBalance))
+	}
+	if filter.MaxBalance != nil {
+		conds = append(conds, "balance <= "+arg(*filter.MaxBalance))
+	}
+
+	sortBy := filter.SortBy
+	if sortBy == "" {
+		sortBy = models.UserSortByID
+	}
+	var sortExpr string
+	switch sortBy {
+	case models.UserSortByID:
+		sortExpr = "id"
+	case models.UserSortByName:
+		sortExpr = "COALESCE(name, '')"
+	case models.UserSortByBalance:
+		sortExpr = "balance"
+	default:
+		return nil, fmt.Errorf("unsupported sort column %q", sortBy)
+	}
+	dir, cmp := "ASC", ">"
+	if filter.Desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	if filter.Cursor != "" {
+		var cur userCursor
+		if err := decodeCursor(filter.Cursor, &cur); err != nil {
+			return nil, mapError(err)
+		}
+		if cur.SortBy != sortBy {
+			return nil, fmt.Errorf("%w: cursor was issued for sort %q", ErrInvalidCursor, cur.SortBy)
+		}
+		switch sortBy {
+		case models.UserSortByID:
+			conds = append(conds, "id "+cmp+" "+arg(cur.Id))
+		case models.UserSortByName:
+			conds = append(conds, fmt.Sprintf("(%s, id) %s (%s, %s)", sortExpr, cmp, arg(cur.Name), arg(cur.Id)))
+		case models.UserSortByBalance:
+			conds = append(conds, fmt.Sprintf("(%s, id) %s (%s, %s)", sortExpr, cmp, arg(cur.Balance), arg(cur.Id)))
+		}
+	}
+
+	query := "SELECT id, COALESCE(name, ''), COALESCE(email, ''), balance, balance - held, currency, currency_exponent, version FROM users"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY " + sortExpr + " " + dir
+	if sortBy != models.UserSortByID {
+		query += ", id " + dir
+	}
+	// one extra row tells whether there is a next page
+	query += " LIMIT " + arg(filter.Limit+1)
+
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "list_users", err)
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	page := &models.UserPage{Users: []models.User{}}
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.Id, &user.Name, &user.Email, &user.Balance, &user.AvailableBalance, &user.Currency, &user.CurrencyExponent, &user.Version); err != nil {
+			span.RecordError(err)
+			telemetry.RecordErrorMetric(ctx, "scan_user", err)
+			return nil, mapError(err)
+		}
+		page.Users = append(page.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "list_users", err)
+		return nil, mapError(err)
+	}
+
+	if len(page.Users) > filter.Limit {
+		page.Users = page.Users[:filter.Limit]
+		last := page.Users[len(page.Users)-1]
+		page.NextCursor = encodeCursor(userCursor{
+			SortBy:  sortBy,
+			Name:    last.Name,
+			Balance: last.Balance,
+			Id:      last.Id,
+		})
+	}
+
+	duration := time.Since(start).Milliseconds()
+	span.SetAttributes(
+		attribute.Int64("db_query.time_ms", duration),
+		attribute.Int("db_query.rows", len(page.Users)),
+	)
+
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return page, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s so that it is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// UpdateUser replaces the user's profile fields; the balance only changes
+// through the ledger. A non-zero version makes the update conditional on the
+// row still being at that version.
+func (r *UserRepository) UpdateUser(ctx context.Context, id int64, name, email string, version int64) (*models.User, error) {
 	ctx, span := r.tracer.Start(ctx, "Repository.UpdateUser")
 	defer span.End()
 
 	start := time.Now()
 
-	query := "UPDATE users SET name = $1, email = $2, balance = $3 WHERE id = $4"
-	_, err := r.db.Exec(ctx, query, name, email, balance, id)
+	query := `UPDATE users SET name = $1, email = $2, version = version + 1
+		WHERE id = $3 AND ($4::bigint = 0 OR version = $4)
+		RETURNING id, name, email, balance, balance - held, currency, currency_exponent, version`
+	var user models.User
+	err := r.conn(ctx).QueryRow(ctx, query, name, email, id, version).Scan(&user.Id, &user.Name, &user.Email, &user.Balance, &user.AvailableBalance, &user.Currency, &user.CurrencyExponent, &user.Version)
+	if errors.Is(err, pgx.ErrNoRows) && version != 0 {
+		err = r.versionConflict(ctx, id)
+	}
 	if err != nil {
 		span.RecordError(err)
 		telemetry.ErrorCounter.Add(ctx, 1, metric.WithAttributes(
@@ -125,7 +349,7 @@ func (r *UserRepository) UpdateUser(ctx context.Context, id int64, name, email s
 			attribute.String("error.msg", err.Error()),
 			attribute.String("query", query),
 		))
-		return nil, err
+		return nil, mapError(err)
 	}
 
 	duration := time.Since(start).Milliseconds()
@@ -137,76 +361,201 @@ func (r *UserRepository) UpdateUser(ctx context.Context, id int64, name, email s
 	if telemetry.RepoLatencyRecorder != nil {
 		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
 	}
-	return &models.User{
-		Id:      id,
-		Name:    name,
-		Email:   email,
-		Balance: balance,
-	}, nil
-	
+	return &user, nil
 }
 
-func (r *UserRepository) TransferFunds(ctx context.Context, fromId, toId, balance int64) (*models.User, error) {
-	ctx, span := r.tracer.Start(ctx, "Repository.TransferFunds")
+// PatchUser updates only the fields set in patch and returns the row as
+// stored after the update. version has the same meaning as in UpdateUser.
+func (r *UserRepository) PatchUser(ctx context.Context, id int64, patch models.UserPatch, version int64) (*models.User, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.PatchUser")
 	defer span.End()
 
-	start:= time.Now()
+	start := time.Now()
 
-	tx, err := r.db.Begin(ctx)
+	var user models.User
+	query := `UPDATE users SET
+			name = COALESCE($1, name),
+			email = COALESCE($2, email),
+			version = version + 1
+		WHERE id = $3 AND ($4::bigint = 0 OR version = $4)
+		RETURNING id, name, email, balance, balance - held, currency, currency_exponent, version`
+	err := r.conn(ctx).QueryRow(ctx, query, patch.Name, patch.Email, id, version).Scan(&user.Id, &user.Name, &user.Email, &user.Balance, &user.AvailableBalance, &user.Currency, &user.CurrencyExponent, &user.Version)
+	if errors.Is(err, pgx.ErrNoRows) && version != 0 {
+		err = r.versionConflict(ctx, id)
+	}
 	if err != nil {
 		span.RecordError(err)
-		telemetry.RecordErrorMetric(ctx, "begin_transaction", err)
-		return nil, err
+		telemetry.ErrorCounter.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("method:", "PatchUser"),
+			attribute.String("error.type", fmt.Sprintf("%T", err)),
+			attribute.String("error.msg", err.Error()),
+			attribute.String("query", query),
+		))
+		return nil, mapError(err)
 	}
-	defer tx.Rollback(ctx)
 
-	query1 := "UPDATE users SET balance = balance - $1 WHERE id = $2"
-	_, err = tx.Exec(ctx, query1, balance, fromId)
+	duration := time.Since(start).Milliseconds()
+	span.SetAttributes(
+		attribute.Int64("db_query.time_ms", duration),
+		attribute.Int64("db_query.user_id", id),
+	)
+
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return &user, nil
+}
+
+// TransferFunds moves balance between two users holding currency and
+// records the movement in the transfers ledger within the same transaction.
+// Called inside WithinTx it becomes part of the caller's transaction.
+func (r *UserRepository) TransferFunds(ctx context.Context, fromId, toId, balance int64, currency string) (*models.Transfer, error) {
+	return r.recordTransfer(ctx, "Repository.TransferFunds", models.Transfer{
+		FromId:   fromId,
+		ToId:     toId,
+		Amount:   balance,
+		Currency: currency,
+		Status:   models.TransferStatusCompleted,
+	})
+}
+
+// ExchangeFunds debits amount of quote.From from one user and credits it,
+// converted at the quoted rate, to a user holding quote.To.
+func (r *UserRepository) ExchangeFunds(ctx context.Context, fromId, toId, amount int64, quote currency.Quote) (*models.Transfer, error) {
+	transfer, err := exchange(fromId, toId, amount, quote)
 	if err != nil {
-		span.RecordError(err)
-		telemetry.RecordErrorMetric(ctx, "update_balance_from", err)	
 		return nil, err
 	}
-	query2 := "UPDATE users SET balance = balance + $1 WHERE id = $2"
-	_, err = tx.Exec(ctx, query2, balance, toId)
+	return r.recordTransfer(ctx, "Repository.ExchangeFunds", transfer)
+}
+
+// exchange plans a converted transfer. An amount too small to be worth a
+// single minor unit of the target currency is rejected, and so is one too
+// large to be stored once converted.
+func exchange(fromId, toId, amount int64, quote currency.Quote) (models.Transfer, error) {
+	credited, err := quote.Convert(amount)
 	if err != nil {
-		span.RecordError(err)
-		telemetry.RecordErrorMetric(ctx, "update_balance_to", err)
-		return nil, err
+		return models.Transfer{}, &ValidationError{Fields: []FieldError{{
+			Field:   "balance",
+			Message: fmt.Sprintf("is too large to convert to %s", quote.To.Code),
+		}}}
 	}
-	if err := tx.Commit(ctx); err != nil {
-		span.RecordError(err)
-		telemetry.RecordErrorMetric(ctx, "commit_transaction", err)
-		return nil, err
+	if credited <= 0 {
+		return models.Transfer{}, &ValidationError{Fields: []FieldError{{
+			Field:   "balance",
+			Message: fmt.Sprintf("is too small to convert to %s", quote.To.Code),
+		}}}
 	}
+	rate := currency.FormatRate(quote.Rate)
+	return models.Transfer{
+		FromId:     fromId,
+		ToId:       toId,
+		Amount:     amount,
+		Currency:   quote.From.Code,
+		ToAmount:   &credited,
+		ToCurrency: &quote.To.Code,
+		Rate:       &rate,
+		Status:     models.TransferStatusCompleted,
+	}, nil
+}
+
+// recordTransfer runs transfer in its own transaction, or in the caller's.
+func (r *UserRepository) recordTransfer(ctx context.Context, spanName string, transfer models.Transfer) (*models.Transfer, error) {
+	ctx, span := r.tracer.Start(ctx, spanName)
+	defer span.End()
+
+	start := time.Now()
 
-	var newBalance int64
-	err = r.db.QueryRow(ctx, "SELECT balance FROM users WHERE id = $1", toId).Scan(&newBalance)
+	err := r.tx.WithinTx(ctx, func(ctx context.Context) error {
+		return r.transfer(ctx, &transfer)
+	})
 	if err != nil {
 		span.RecordError(err)
-		telemetry.RecordErrorMetric(ctx, "select_balance", err)
 		return nil, err
 	}
 
 	duration := time.Since(start).Milliseconds()
 	span.SetAttributes(
 		attribute.Int64("db_query.time_ms", duration),
-		attribute.Int64("db_query.user_fromId", fromId),
-		attribute.Int64("db_query.user_toId", toId),
+		attribute.Int64("db_query.user_fromId", transfer.FromId),
+		attribute.Int64("db_query.user_toId", transfer.ToId),
+		attribute.Int64("db_query.transfer_id", transfer.Id),
 	)
 
 	if telemetry.RepoLatencyRecorder != nil {
 		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
 	}
 
-	return &models.User{
-		Id:      toId,
-		Balance: newBalance,
-	}, nil
-	
+	return &transfer, nil
+}
+
+// transfer moves t.Amount from t.FromId to t.ToId, records t and books it in
+// the ledger. It must run inside a transaction.
+func (r *UserRepository) transfer(ctx context.Context, t *models.Transfer) error {
+	accounts, err := r.lockAccounts(ctx, t.FromId, t.ToId)
+	if err != nil {
+		return err
+	}
+	if err := checkTransfer(accounts, t); err != nil {
+		return err
+	}
+	if err := r.insertTransfer(ctx, t); err != nil {
+		return err
+	}
+	return r.post(ctx, LedgerTransfer, &t.Id, transferEntries(t)...)
+}
+
+// insertTransfer records t and fills in its id and creation time.
+func (r *UserRepository) insertTransfer(ctx context.Context, t *models.Transfer) error {
+	query := `INSERT INTO transfers (from_id, to_id, amount, currency, to_amount, to_currency, rate, status, reversal_of)
+		VALUES ($1, $2, $3, $4, $5, $6, $7::numeric, $8, $9) RETURNING id, created_at`
+	err := r.conn(ctx).QueryRow(ctx, query, t.FromId, t.ToId, t.Amount, t.Currency, t.ToAmount, t.ToCurrency, t.Rate, t.Status, t.ReversalOf).Scan(&t.Id, &t.CreatedAt)
+	if err != nil {
+		telemetry.RecordErrorMetric(ctx, "insert_transfer", err)
+		return mapError(err)
+	}
+	return nil
+}
+
+// checkTransfer verifies t against the locked accounts involved: both must
+// exist, hold the currencies t is debited and credited in, and the sender's
+// available balance must cover the amount.
+func checkTransfer(accounts map[int64]account, t *models.Transfer) error {
+	for _, id := range []int64{t.FromId, t.ToId} {
+		if _, ok := accounts[id]; !ok {
+			return &AccountNotFoundError{AccountID: id}
+		}
+	}
+	from, to := accounts[t.FromId], accounts[t.ToId]
+	_, toCurrency := t.Credited()
+	if from.Currency != t.Currency {
+		return &CurrencyMismatchError{AccountID: t.FromId, Currency: from.Currency, Expected: t.Currency}
+	}
+	if to.Currency != toCurrency {
+		return &CurrencyMismatchError{AccountID: t.ToId, Currency: to.Currency, Expected: toCurrency}
+	}
+	if from.Available() < t.Amount {
+		return &InsufficientFundsError{AccountID: t.FromId, Balance: from.Available(), Amount: t.Amount}
+	}
+	return nil
+}
+
+// execOne runs a statement that must change exactly the row of account id.
+func (r *UserRepository) execOne(ctx context.Context, query string, id int64, args ...any) error {
+	tag, err := r.conn(ctx).Exec(ctx, query, args...)
+	if err != nil {
+		return mapError(err)
+	}
+	if tag.RowsAffected() != 1 {
+		return &AccountNotFoundError{AccountID: id}
+	}
+	return nil
 }
 
-func (r *UserRepository) DeleteUser(ctx context.Context, id int64) error {
+// DeleteUser removes the user. version has the same meaning as in UpdateUser.
+// Only an account that holds nothing and has never moved money can go: the
+// ledger, transfers and holds reference it, so that the books stay complete.
+func (r *UserRepository) DeleteUser(ctx context.Context, id int64, version int64) error {
 	ctx, span := r.tracer.Start(ctx, "Repository.DeleteUser")
 	defer span.End()
 
@@ -214,7 +563,30 @@ func (r *UserRepository) DeleteUser(ctx context.Context, id int64) error {
 
 	start := time.Now()
 
-	_, err := r.db.Exec(ctx, query, id)
+	err := r.tx.WithinTx(ctx, func(ctx context.Context) error {
+		var current, balance, held int64
+		err := r.conn(ctx).QueryRow(ctx, "SELECT version, balance, held FROM users WHERE id = $1 FOR UPDATE", id).
+			Scan(&current, &balance, &held)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if version != 0 && current != version {
+			return ErrVersionMismatch
+		}
+		if balance != 0 || held != 0 {
+			return &AccountInUseError{AccountID: id, Balance: balance, Held: held}
+		}
+
+		_, err = r.conn(ctx).Exec(ctx, query, id)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+			return &AccountInUseError{AccountID: id}
+		}
+		return err
+	})
 	if err != nil {
 		span.RecordError(err)
 		telemetry.ErrorCounter.Add(ctx, 1, metric.WithAttributes(
@@ -223,7 +595,7 @@ func (r *UserRepository) DeleteUser(ctx context.Context, id int64) error {
 			attribute.String("error.msg", err.Error()),
 			attribute.String("query", query),
 		))
-		return err
+		return mapError(err)
 	}
 
 	duration := time.Since(start).Milliseconds()
@@ -239,3 +611,16 @@ func (r *UserRepository) DeleteUser(ctx context.Context, id int64) error {
 	return err
 }
 
+// versionConflict explains why a conditional write on id matched no rows:
+// either the user is gone or it has moved past the expected version.
+func (r *UserRepository) versionConflict(ctx context.Context, id int64) error {
+	var exists bool
+	err := r.conn(ctx).QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", id).Scan(&exists)
+	if err != nil {
+		return mapError(err)
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return ErrVersionMismatch
+}
@@ -0,0 +1,273 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/lahaehae/crud_project/internal/currency"
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const transferColumns = "id, from_id, to_id, amount, currency, to_amount, to_currency, rate::text, status, reversal_of, reversed_amount, created_at"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTransfer(row rowScanner, t *models.Transfer) error {
+	return row.Scan(&t.Id, &t.FromId, &t.ToId, &t.Amount, &t.Currency, &t.ToAmount, &t.ToCurrency, &t.Rate,
+		&t.Status, &t.ReversalOf, &t.ReversedAmount, &t.CreatedAt)
+}
+
+// GetTransfer returns a single transfer by id.
+func (r *UserRepository) GetTransfer(ctx context.Context, id int64) (*models.Transfer, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.GetTransfer")
+	defer span.End()
+
+	start := time.Now()
+
+	var t models.Transfer
+	query := "SELECT " + transferColumns + " FROM transfers WHERE id = $1"
+	err := scanTransfer(r.conn(ctx).QueryRow(ctx, query, id), &t)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "get_transfer", err)
+		return nil, mapError(err)
+	}
+
+	span.SetAttributes(attribute.Int64("db_query.transfer_id", id))
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return &t, nil
+}
+
+type transferCursor struct {
+	CreatedAt time.Time `json:"t"`
+	Id        int64     `json:"id"`
+}
+
+// ListTransfers returns one page of a user's transfers, newest first, using
+// keyset pagination on (created_at, id).
+//
+// Both directions are read as a UNION ALL of two branches so that each can
+// walk its own (from_id|to_id, created_at, id) index and stop after one page,
+// however long the history is.
+func (r *UserRepository) ListTransfers(ctx context.Context, filter models.TransferFilter) (*models.TransferPage, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.ListTransfers")
+	defer span.End()
+
+	start := time.Now()
+
+	var exists bool
+	err := r.conn(ctx).QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", filter.UserId).Scan(&exists)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "list_transfers", err)
+		return nil, mapError(err)
+	}
+	if !exists {
+		return nil, &AccountNotFoundError{AccountID: filter.UserId}
+	}
+
+	var (
+		conds []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	user := arg(filter.UserId)
+	if filter.Since != nil {
+		conds = append(conds, "created_at >= "+arg(*filter.Since))
+	}
+	if filter.Until != nil {
+		conds = append(conds, "created_at < "+arg(*filter.Until))
+	}
+	if filter.MinAmount != nil {
+		conds = append(conds, "amount >= "+arg(*filter.MinAmount))
+	}
+	if filter.MaxAmount != nil {
+		conds = append(conds, "amount <= "+arg(*filter.MaxAmount))
+	}
+	if filter.Cursor != "" {
+		var cur transferCursor
+		if err := decodeCursor(filter.Cursor, &cur); err != nil {
+			return nil, err
+		}
+		conds = append(conds, fmt.Sprintf("(created_at, id) < (%s, %s)", arg(cur.CreatedAt), arg(cur.Id)))
+	}
+	// one extra row tells whether there is a next page
+	limit := arg(filter.Limit + 1)
+
+	branch := func(cond string) string {
+		where := strings.Join(append([]string{cond}, conds...), " AND ")
+		return "(SELECT " + transferColumns + " FROM transfers WHERE " + where +
+			" ORDER BY created_at DESC, id DESC LIMIT " + limit + ")"
+	}
+	var query string
+	switch filter.Direction {
+	case models.TransferDirectionOut:
+		query = branch("from_id = " + user)
+	case models.TransferDirectionIn:
+		query = branch("to_id = " + user)
+	default:
+		query = "SELECT " + transferColumns + " FROM (" +
+			branch("from_id = "+user) + " UNION ALL " + branch("to_id = "+user+" AND from_id <> "+user) +
+			") t ORDER BY created_at DESC, id DESC LIMIT " + limit
+	}
+
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "list_transfers", err)
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	page := &models.TransferPage{Transfers: []models.Transfer{}}
+	for rows.Next() {
+		var t models.Transfer
+		if err := scanTransfer(rows, &t); err != nil {
+			span.RecordError(err)
+			telemetry.RecordErrorMetric(ctx, "scan_transfer", err)
+			return nil, mapError(err)
+		}
+		page.Transfers = append(page.Transfers, t)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "list_transfers", err)
+		return nil, mapError(err)
+	}
+
+	if len(page.Transfers) > filter.Limit {
+		page.Transfers = page.Transfers[:filter.Limit]
+		last := page.Transfers[len(page.Transfers)-1]
+		page.NextCursor = encodeCursor(transferCursor{CreatedAt: last.CreatedAt, Id: last.Id})
+	}
+
+	span.SetAttributes(
+		attribute.Int64("db_query.user_id", filter.UserId),
+		attribute.Int("db_query.rows", len(page.Transfers)),
+	)
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return page, nil
+}
+
+// ReverseTransfer refunds amount of transfer id with a transfer in the
+// opposite direction; zero refunds whatever is left. The reversal and the
+// updated status of the original are written in one transaction.
+func (r *UserRepository) ReverseTransfer(ctx context.Context, id, amount int64) (*models.Transfer, error) {
+	ctx, span := r.tracer.Start(ctx, "Repository.ReverseTransfer")
+	defer span.End()
+
+	start := time.Now()
+
+	var reversal models.Transfer
+	err := r.tx.WithinTx(ctx, func(ctx context.Context) error {
+		var original models.Transfer
+		query := "SELECT " + transferColumns + " FROM transfers WHERE id = $1 FOR UPDATE"
+		if err := scanTransfer(r.conn(ctx).QueryRow(ctx, query, id), &original); err != nil {
+			telemetry.RecordErrorMetric(ctx, "lock_transfer", err)
+			return mapError(err)
+		}
+
+		var err error
+		if reversal, err = PlanReversal(&original, amount); err != nil {
+			return err
+		}
+		if err := r.transfer(ctx, &reversal); err != nil {
+			return err
+		}
+
+		query = "UPDATE transfers SET reversed_amount = $1, status = $2 WHERE id = $3"
+		if _, err := r.conn(ctx).Exec(ctx, query, original.ReversedAmount, original.Status, id); err != nil {
+			telemetry.RecordErrorMetric(ctx, "update_reversed_transfer", err)
+			return mapError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.Int64("db_query.transfer_id", id),
+		attribute.Int64("db_query.reversal_id", reversal.Id),
+	)
+	if telemetry.RepoLatencyRecorder != nil {
+		telemetry.RepoLatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return &reversal, nil
+}
+
+// PlanReversal checks that amount of original can be refunded, applies the
+// refund to original and returns the reversal to record. It writes nothing,
+// so the service can learn the reversal ReverseTransfer will make.
+//
+// A converted transfer is refunded at its original rate: the recipient pays
+// back the share of ToAmount that amount stands for, so that the refunds of
+// a transfer reversed in parts add up to exactly ToAmount.
+func PlanReversal(original *models.Transfer, amount int64) (models.Transfer, error) {
+	if original.ReversalOf != nil {
+		return models.Transfer{}, ErrNotReversible
+	}
+	reversible := original.Reversible()
+	if reversible == 0 {
+		return models.Transfer{}, ErrAlreadyReversed
+	}
+	if amount == 0 {
+		amount = reversible
+	}
+	if amount > reversible {
+		return models.Transfer{}, &ReversalExceededError{TransferID: original.Id, Reversible: reversible, Amount: amount}
+	}
+
+	reversal := models.Transfer{
+		FromId:     original.ToId,
+		ToId:       original.FromId,
+		Amount:     amount,
+		Currency:   original.Currency,
+		Status:     models.TransferStatusCompleted,
+		ReversalOf: &original.Id,
+	}
+	if original.ToAmount != nil {
+		share := func(reversed int64) int64 {
+			return new(big.Int).Div(
+				new(big.Int).Mul(big.NewInt(reversed), big.NewInt(*original.ToAmount)),
+				big.NewInt(original.Amount),
+			).Int64()
+		}
+		refund := share(original.ReversedAmount+amount) - share(original.ReversedAmount)
+		if refund == 0 {
+			return models.Transfer{}, &ValidationError{Fields: []FieldError{{
+				Field:   "amount",
+				Message: fmt.Sprintf("is too small to refund from %s", *original.ToCurrency),
+			}}}
+		}
+		rate := currency.FormatRate(big.NewRat(amount, refund))
+		reversal.Amount = refund
+		reversal.Currency = *original.ToCurrency
+		reversal.ToAmount = &amount
+		reversal.ToCurrency = &original.Currency
+		reversal.Rate = &rate
+	}
+
+	original.ReversedAmount += amount
+	original.Status = models.TransferStatusPartiallyReversed
+	if original.ReversedAmount == original.Amount {
+		original.Status = models.TransferStatusReversed
+	}
+	return reversal, nil
+}
@@ -0,0 +1,190 @@
+// Package scheduler runs the transfers of due scheduled transfers.
+//
+// Any number of workers, in one process or on several replicas, may poll the
+// same store: each occurrence is claimed under a row lock that other workers
+// skip, and its transfer and recorded outcome commit together, so that an
+// occurrence is paid at most once.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/repository"
+	"github.com/lahaehae/crud_project/internal/service"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config tunes how often the worker polls and how it retries.
+type Config struct {
+	// PollInterval is the time between two looks for due schedules.
+	PollInterval time.Duration
+	// BatchSize caps the occurrences run per poll.
+	BatchSize int
+	// MaxAttempts is how often an occurrence is tried before it is given up.
+	MaxAttempts int
+	// RetryDelay is the wait before the first retry; it doubles with every
+	// further attempt.
+	RetryDelay time.Duration
+}
+
+// Worker runs due occurrences through UserService.TransferFunds. store and
+// tx must belong to the same storage as the service.
+type Worker struct {
+	store     repository.ScheduleStore
+	tx        repository.TxManager
+	transfers *service.UserService
+	cfg       Config
+	tracer    trace.Tracer
+}
+
+func NewWorker(store repository.ScheduleStore, tx repository.TxManager, transfers *service.UserService, cfg Config) *Worker {
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 1
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	return &Worker{
+		store:     store,
+		tx:        tx,
+		transfers: transfers,
+		cfg:       cfg,
+		tracer:    otel.Tracer("scheduler"),
+	}
+}
+
+// Run polls for due schedules until ctx is cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for i := 0; i < w.cfg.BatchSize; i++ {
+			ran, err := w.RunOnce(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Failed to run scheduled transfer: %v", err)
+				}
+				break
+			}
+			if !ran {
+				break
+			}
+		}
+	}
+}
+
+// RunOnce claims the schedule that has been due the longest and runs its
+// occurrence. It reports false when nothing was due.
+func (w *Worker) RunOnce(ctx context.Context) (bool, error) {
+	ctx, span := w.tracer.Start(ctx, "Scheduler.RunOnce")
+	defer span.End()
+
+	var ran bool
+	err := w.tx.WithinTx(ctx, func(ctx context.Context) error {
+		ran = false
+		st, err := w.store.ClaimDueSchedule(ctx, time.Now())
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ran = true
+		span.SetAttributes(attribute.Int64("schedule.id", st.Id))
+		return w.run(ctx, st)
+	})
+	if err != nil {
+		span.RecordError(err)
+		return false, err
+	}
+	return ran, nil
+}
+
+// run makes the transfer of the claimed schedule st and records the outcome.
+func (w *Worker) run(ctx context.Context, st *models.ScheduledTransfer) error {
+	occurrence := *st.NextRunAt
+	run := models.ScheduledTransferRun{ScheduledFor: occurrence, Attempt: st.Attempt + 1}
+
+	// a failed transfer only rolls back to here, so that its outcome can
+	// still be recorded
+	var transfer *models.Transfer
+	err := w.tx.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		transfer, err = w.transfers.TransferFunds(ctx, st.FromId, st.ToId, st.Amount, st.Currency)
+		return err
+	})
+	if ctx.Err() != nil {
+		// shutting down: leave the occurrence to the next worker
+		return ctx.Err()
+	}
+	if repository.Retryable(err) {
+		// not the transfer's fault: re-run the claim and all
+		return err
+	}
+
+	now := time.Now().UTC()
+	switch {
+	case err == nil:
+		run.Status = models.ScheduleRunSucceeded
+		run.TransferId = &transfer.Id
+		advance(st, occurrence, now)
+	case retryable(err) && run.Attempt < w.cfg.MaxAttempts:
+		retryAt := now.Add(w.cfg.RetryDelay << (run.Attempt - 1))
+		run.Status = models.ScheduleRunFailed
+		run.Error = err.Error()
+		run.RetryAt = &retryAt
+		st.Attempt = run.Attempt
+		st.RetryAt = &retryAt
+	default:
+		run.Status = models.ScheduleRunFailed
+		run.Error = err.Error()
+		advance(st, occurrence, now)
+	}
+	return w.store.SaveScheduleRun(ctx, st, &run)
+}
+
+// advance moves st past occurrence, which counts as a run whether it
+// succeeded or was given up. Occurrences missed while the scheduler was
+// down or retrying are skipped rather than made up for.
+func advance(st *models.ScheduledTransfer, occurrence, now time.Time) {
+	st.RunCount++
+	st.Attempt = 0
+	st.RetryAt = nil
+	next, ok := service.NextRun(st, later(occurrence, now))
+	if !ok {
+		st.Status = models.ScheduleStatusCompleted
+		st.NextRunAt = nil
+		return
+	}
+	st.NextRunAt = &next
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// retryable reports whether a failed transfer may succeed when tried again.
+// A transfer the service rejects as invalid or unknown never will; missing
+// funds or exchange rates may turn up, and anything else is taken to be a
+// passing storage failure. Serialization failures and deadlocks never get
+// here: run hands them to the transaction to re-run.
+func retryable(err error) bool {
+	return !errors.Is(err, repository.ErrValidation) &&
+		!errors.Is(err, repository.ErrNotFound) &&
+		!errors.Is(err, repository.ErrCurrencyMismatch) &&
+		!errors.Is(err, repository.ErrConflict)
+}
@@ -0,0 +1,176 @@
+package scheduler
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lahaehae/crud_project/internal/currency"
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/repository"
+	"github.com/lahaehae/crud_project/internal/service"
+	"github.com/lahaehae/crud_project/internal/telemetry"
+)
+
+func TestMain(m *testing.M) {
+	telemetry.InitMetrics()
+	os.Exit(m.Run())
+}
+
+// cancellingStore cancels every schedule right after it has been claimed,
+// as a client could while its occurrence runs.
+type cancellingStore struct {
+	*repository.MemoryScheduleRepository
+}
+
+func (s cancellingStore) ClaimDueSchedule(ctx context.Context, now time.Time) (*models.ScheduledTransfer, error) {
+	st, err := s.MemoryScheduleRepository.ClaimDueSchedule(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.CancelSchedule(ctx, st.Id); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// deadlockingRepo fails every transfer as Postgres does the victim of a
+// deadlock.
+type deadlockingRepo struct {
+	*repository.MemoryUserRepository
+}
+
+func (r deadlockingRepo) TransferFunds(ctx context.Context, fromId, toId, amount int64, currency string) (*models.Transfer, error) {
+	return nil, &pgconn.PgError{Code: "40P01", Message: "deadlock detected"}
+}
+
+// newTestWorker returns a worker over in-memory storage with two funded
+// accounts and a schedule moving 100 between them every minute, due now.
+// wrapStore and wrapUsers may stand in for the storage the worker and its
+// service use.
+func newTestWorker(t *testing.T, wrapStore func(*repository.MemoryScheduleRepository) repository.ScheduleStore, wrapUsers func(*repository.MemoryUserRepository) repository.UserRepo) (*Worker, *repository.MemoryScheduleRepository, *service.UserService, int64) {
+	t.Helper()
+	ctx := context.Background()
+	rates, err := currency.ParseStaticRates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := repository.NewMemoryUserRepository()
+	transfers := service.NewUserService(wrapUsers(users), users, rates, service.Config{})
+	from, err := transfers.CreateUser(ctx, "From", "from@example.com", "USD", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := transfers.CreateUser(ctx, "To", "to@example.com", "USD", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schedules := repository.NewMemoryScheduleRepository()
+	start := time.Now().UTC().Add(-time.Second)
+	st, err := schedules.CreateSchedule(ctx, models.ScheduledTransfer{
+		FromId:    from.Id,
+		ToId:      to.Id,
+		Amount:    100,
+		Currency:  "USD",
+		Interval:  "1m",
+		StartAt:   start,
+		Status:    models.ScheduleStatusActive,
+		NextRunAt: &start,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker := NewWorker(wrapStore(schedules), users, transfers, Config{PollInterval: time.Minute, BatchSize: 1, MaxAttempts: 3, RetryDelay: time.Minute})
+	return worker, schedules, transfers, st.Id
+}
+
+func asStore(r *repository.MemoryScheduleRepository) repository.ScheduleStore { return r }
+
+func asUserRepo(r *repository.MemoryUserRepository) repository.UserRepo { return r }
+
+func TestRunOnce(t *testing.T) {
+	ctx := context.Background()
+	worker, schedules, _, id := newTestWorker(t, asStore, asUserRepo)
+
+	ran, err := worker.RunOnce(ctx)
+	if err != nil || !ran {
+		t.Fatalf("RunOnce: ran %v, error %v; want a run", ran, err)
+	}
+	st, err := schedules.GetSchedule(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Status != models.ScheduleStatusActive || st.RunCount != 1 || st.NextRunAt == nil || !st.NextRunAt.After(time.Now()) {
+		t.Errorf("schedule is %s after %d runs, next at %v; want it active and due in the future", st.Status, st.RunCount, st.NextRunAt)
+	}
+	if ran, err := worker.RunOnce(ctx); err != nil || ran {
+		t.Errorf("second RunOnce: ran %v, error %v; want nothing due", ran, err)
+	}
+}
+
+func TestRunOnceKeepsCancelDuringRun(t *testing.T) {
+	ctx := context.Background()
+	worker, schedules, transfers, id := newTestWorker(t, func(r *repository.MemoryScheduleRepository) repository.ScheduleStore {
+		return cancellingStore{r}
+	}, asUserRepo)
+
+	if ran, err := worker.RunOnce(ctx); err != nil || !ran {
+		t.Fatalf("RunOnce: ran %v, error %v; want a run", ran, err)
+	}
+
+	st, err := schedules.GetSchedule(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Status != models.ScheduleStatusCancelled || st.NextRunAt != nil {
+		t.Fatalf("schedule is %s, next at %v; want the cancel kept", st.Status, st.NextRunAt)
+	}
+	// the occurrence that was already running is paid and recorded
+	runs, err := schedules.ListScheduleRuns(ctx, id, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Status != models.ScheduleRunSucceeded {
+		t.Fatalf("runs are %+v, want one that succeeded", runs)
+	}
+	from, err := transfers.GetUser(ctx, st.FromId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.Balance != 900 {
+		t.Errorf("sender has %d, want 900", from.Balance)
+	}
+}
+
+func TestRunOnceLeavesRetryableFailureToTransaction(t *testing.T) {
+	ctx := context.Background()
+	worker, schedules, _, id := newTestWorker(t, asStore, func(r *repository.MemoryUserRepository) repository.UserRepo {
+		return deadlockingRepo{r}
+	})
+	before, err := schedules.GetSchedule(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the deadlock is the transaction's to retry, not a failed attempt
+	if _, err := worker.RunOnce(ctx); !repository.Retryable(err) {
+		t.Fatalf("RunOnce: error %v, want the deadlock", err)
+	}
+	st, err := schedules.GetSchedule(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Attempt != 0 || st.RetryAt != nil || st.RunCount != 0 || !st.NextRunAt.Equal(*before.NextRunAt) {
+		t.Errorf("schedule is at attempt %d after %d runs, retry at %v, next at %v; want it untouched", st.Attempt, st.RunCount, st.RetryAt, st.NextRunAt)
+	}
+	runs, err := schedules.ListScheduleRuns(ctx, id, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 0 {
+		t.Errorf("runs are %+v, want none recorded", runs)
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/repository"
+	"github.com/lahaehae/crud_project/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Modes of a batch transfer: atomic makes all transfers or none, best_effort
+// makes those it can and reports the others.
+const (
+	BatchModeAtomic     = "atomic"
+	BatchModeBestEffort = "best_effort"
+)
+
+// MaxBatchSize caps the number of transfers in one batch.
+const MaxBatchSize = 1000
+
+type batchInput struct {
+	Mode      string                 `json:"mode" validate:"required"`
+	Transfers []models.TransferOrder `json:"transfers" validate:"min=1,max=1000"`
+}
+
+// TransferBatch makes orders, in the order given, in one transaction. Each
+// order is checked like a single TransferFunds call, limits included, and
+// counts against the limits of the orders after it once it is made. In
+// atomic mode the first order that fails aborts the
+// batch with a *repository.BatchItemError; in best_effort mode it is
+// reported in its result and the rest go ahead. The results are in the
+// order of orders.
+func (s *UserService) TransferBatch(ctx context.Context, mode string, orders []models.TransferOrder) ([]repository.BatchResult, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.TransferBatch")
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "TransferBatch"),
+			),
+		)
+	}
+
+	var extra []repository.FieldError
+	if mode != "" && mode != BatchModeAtomic && mode != BatchModeBestEffort {
+		extra = append(extra, repository.FieldError{Field: "mode", Message: "must be " + BatchModeAtomic + " or " + BatchModeBestEffort})
+	}
+	if err := validateInput(batchInput{Mode: mode, Transfers: orders}, extra...); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	atomic := mode == BatchModeAtomic
+	span.SetAttributes(
+		attribute.String("batch.mode", mode),
+		attribute.Int("batch.size", len(orders)),
+	)
+
+	// orders that fail before reaching the repository keep their slot in
+	// results; planned[i] is the position of batch[i] in orders
+	results := make([]repository.BatchResult, len(orders))
+	batch := make([]repository.BatchOrder, 0, len(orders))
+	planned := make([]int, 0, len(orders))
+	for i, o := range orders {
+		err := s.validateTransfer(o.FromId, o.ToId, o.Amount, o.Currency)
+		if err == nil {
+			var order repository.BatchOrder
+			order.TransferOrder = o
+			if order.Quote, err = s.quote(ctx, o.FromId, o.ToId, o.Currency); err == nil {
+				batch = append(batch, order)
+				planned = append(planned, i)
+				continue
+			}
+		}
+		if atomic {
+			err = &repository.BatchItemError{Index: i, Err: err}
+			span.RecordError(err)
+			return nil, err
+		}
+		results[i].Err = err
+	}
+	if len(batch) == 0 {
+		return results, nil
+	}
+
+	// the transaction may be re-run, so the limits are tracked afresh on
+	// every attempt
+	var made []repository.BatchResult
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		made, err = s.repo.TransferBatch(ctx, batch, atomic, batchLimits{s.newLimitTracker()})
+		var itemErr *repository.BatchItemError
+		if errors.As(err, &itemErr) {
+			// report the position in the request, not in the planned batch
+			return &repository.BatchItemError{Index: planned[itemErr.Index], Err: itemErr.Err}
+		}
+		return err
+	})
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_transfer_batch", err)
+		return nil, err
+	}
+	for i, result := range made {
+		results[planned[i]] = result
+	}
+	return results, nil
+}
+
+// batchLimits holds the orders of a batch to their senders' limits.
+type batchLimits struct {
+	tracker *limitTracker
+}
+
+func (l batchLimits) Check(ctx context.Context, o repository.BatchOrder) error {
+	return l.tracker.check(ctx, o.FromId, o.Amount)
+}
+
+func (l batchLimits) Count(o repository.BatchOrder) {
+	l.tracker.count(o.FromId, o.Amount)
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/repository"
+)
+
+func TestTransferBatchAtomic(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, Config{})
+	a := createUser(t, s, "USD", 1000)
+	b := createUser(t, s, "USD", 0)
+	c := createUser(t, s, "EUR", 0)
+
+	results, err := s.TransferBatch(ctx, BatchModeAtomic, []models.TransferOrder{
+		{FromId: a.Id, ToId: b.Id, Amount: 300, Currency: "USD"},
+		{FromId: b.Id, ToId: c.Id, Amount: 200, Currency: "USD"},
+		{FromId: a.Id, ToId: c.Id, Amount: 100, Currency: "USD"},
+	})
+	if err != nil {
+		t.Fatalf("TransferBatch: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for i, r := range results {
+		if r.Err != nil || r.Transfer == nil {
+			t.Errorf("transfer %d: %v", i, r.Err)
+		}
+	}
+	// b sends on what it received earlier in the batch
+	assertBalances(t, s, map[int64]int64{a.Id: 600, b.Id: 100, c.Id: 150})
+}
+
+func TestTransferBatchAtomicRollsBack(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		orders    func(a, b int64) []models.TransferOrder
+		wantIndex int
+		wantErr   error
+	}{
+		{
+			name: "insufficient funds",
+			orders: func(a, b int64) []models.TransferOrder {
+				return []models.TransferOrder{
+					{FromId: a, ToId: b, Amount: 600, Currency: "USD"},
+					{FromId: a, ToId: b, Amount: 600, Currency: "USD"},
+				}
+			},
+			wantIndex: 1,
+			wantErr:   repository.ErrInsufficientFunds,
+		},
+		{
+			name: "invalid order",
+			orders: func(a, b int64) []models.TransferOrder {
+				return []models.TransferOrder{
+					{FromId: a, ToId: b, Amount: 100, Currency: "USD"},
+					{FromId: a, ToId: a, Amount: 100, Currency: "USD"},
+				}
+			},
+			wantIndex: 1,
+			wantErr:   repository.ErrValidation,
+		},
+		{
+			name: "missing account",
+			orders: func(a, b int64) []models.TransferOrder {
+				return []models.TransferOrder{
+					{FromId: a, ToId: b + 100, Amount: 100, Currency: "USD"},
+					{FromId: a, ToId: b, Amount: 100, Currency: "USD"},
+				}
+			},
+			wantIndex: 0,
+			wantErr:   repository.ErrAccountNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, Config{})
+			a := createUser(t, s, "USD", 1000)
+			b := createUser(t, s, "USD", 0)
+
+			_, err := s.TransferBatch(ctx, BatchModeAtomic, tt.orders(a.Id, b.Id))
+			var itemErr *repository.BatchItemError
+			if !errors.As(err, &itemErr) || itemErr.Index != tt.wantIndex || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("TransferBatch: error %v, want %v at %d", err, tt.wantErr, tt.wantIndex)
+			}
+			assertBalances(t, s, map[int64]int64{a.Id: 1000, b.Id: 0})
+		})
+	}
+}
+
+func TestTransferBatchBestEffort(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, Config{})
+	a := createUser(t, s, "USD", 1000)
+	b := createUser(t, s, "USD", 0)
+
+	results, err := s.TransferBatch(ctx, BatchModeBestEffort, []models.TransferOrder{
+		{FromId: a.Id, ToId: b.Id, Amount: 600, Currency: "USD"},
+		{FromId: a.Id, ToId: b.Id, Amount: 600, Currency: "USD"},
+		{FromId: a.Id, ToId: a.Id, Amount: 100, Currency: "USD"},
+		{FromId: a.Id, ToId: b.Id, Amount: 100, Currency: "EUR"},
+		{FromId: a.Id, ToId: b.Id, Amount: 400, Currency: "USD"},
+	})
+	if err != nil {
+		t.Fatalf("TransferBatch: %v", err)
+	}
+
+	want := []error{nil, repository.ErrInsufficientFunds, repository.ErrValidation, repository.ErrCurrencyMismatch, nil}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, r := range results {
+		switch {
+		case want[i] == nil && (r.Err != nil || r.Transfer == nil):
+			t.Errorf("transfer %d failed: %v", i, r.Err)
+		case want[i] != nil && (!errors.Is(r.Err, want[i]) || r.Transfer != nil):
+			t.Errorf("transfer %d: error %v, want %v", i, r.Err, want[i])
+		}
+	}
+	// the failed transfer left nothing behind for the ones after it
+	assertBalances(t, s, map[int64]int64{a.Id: 0, b.Id: 1000})
+}
+
+func TestTransferBatchValidation(t *testing.T) {
+	s := newTestService(t, Config{})
+	a := createUser(t, s, "USD", 1000)
+	b := createUser(t, s, "USD", 0)
+	order := models.TransferOrder{FromId: a.Id, ToId: b.Id, Amount: 1, Currency: "USD"}
+
+	tests := []struct {
+		name   string
+		mode   string
+		orders []models.TransferOrder
+	}{
+		{"unknown mode", "sometimes", []models.TransferOrder{order}},
+		{"no mode", "", []models.TransferOrder{order}},
+		{"empty", BatchModeAtomic, nil},
+		{"too large", BatchModeAtomic, make([]models.TransferOrder, MaxBatchSize+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.TransferBatch(context.Background(), tt.mode, tt.orders); !errors.Is(err, repository.ErrValidation) {
+				t.Errorf("error %v, want %v", err, repository.ErrValidation)
+			}
+		})
+	}
+	assertBalances(t, s, map[int64]int64{a.Id: 1000, b.Id: 0})
+}
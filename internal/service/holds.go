@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/repository"
+	"github.com/lahaehae/crud_project/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// CreateHold reserves amount, given in the user's currency code, for a later
+// transfer to toId. The hold expires after the configured HoldTTL.
+func (s *UserService) CreateHold(ctx context.Context, userId, toId, amount int64, code string) (*models.Hold, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.CreateHold")
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "CreateHold"),
+			),
+		)
+	}
+
+	var extra []repository.FieldError
+	if toId != 0 && toId == userId {
+		extra = append(extra, repository.FieldError{Field: "to_id", Message: "must differ from the holder"})
+	}
+	if amount > s.cfg.MaxTransferAmount {
+		extra = append(extra, repository.FieldError{Field: "amount", Message: fmt.Sprintf("must be at most %d", s.cfg.MaxTransferAmount)})
+	}
+	if code != "" {
+		_, unknown := lookupCurrency(code)
+		extra = append(extra, unknown...)
+	}
+	if err := validateInput(holdInput{ToId: toId, Amount: amount, Currency: code}, extra...); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	hold, err := s.repo.CreateHold(ctx, userId, toId, amount, code, time.Now().Add(s.cfg.HoldTTL))
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_create_hold", err)
+		return nil, err
+	}
+	return hold, nil
+}
+
+func (s *UserService) GetHold(ctx context.Context, id int64) (*models.Hold, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.GetHold")
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "GetHold"),
+			),
+		)
+	}
+
+	hold, err := s.repo.GetHold(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_get_hold", err)
+		return nil, err
+	}
+	return hold, nil
+}
+
+// CaptureHold settles amount of a hold, or all of it when amount is zero, as
+// a transfer to the hold's recipient; the rest of the hold is released. A
+// recipient in another currency is paid at the current rate. The transfer is
+// subject to the holder's limits like any other.
+func (s *UserService) CaptureHold(ctx context.Context, id, amount int64) (*models.Transfer, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.CaptureHold")
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "CaptureHold"),
+			),
+		)
+	}
+
+	if err := validateInput(captureInput{Amount: amount}); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	// the parties of a hold never change, so they can be priced up front
+	hold, err := s.repo.GetHold(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_get_hold", err)
+		return nil, err
+	}
+	quote, err := s.quote(ctx, hold.UserId, hold.ToId, hold.Currency)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "quote_transfer", err)
+		return nil, err
+	}
+
+	var transfer *models.Transfer
+	err = s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		// lock the hold before checkLimits locks the accounts, the order
+		// VoidHold and ExpireHolds take them in
+		hold, err := s.repo.LockHold(ctx, id)
+		if err != nil {
+			return err
+		}
+		captured := amount
+		if captured == 0 {
+			captured = hold.Amount
+		}
+		if err := s.checkLimits(ctx, hold.UserId, hold.ToId, captured); err != nil {
+			return err
+		}
+		transfer, err = s.repo.CaptureHold(ctx, id, amount, quote)
+		return err
+	})
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_capture_hold", err)
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// VoidHold releases a hold without moving any money.
+func (s *UserService) VoidHold(ctx context.Context, id int64) (*models.Hold, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.VoidHold")
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "VoidHold"),
+			),
+		)
+	}
+
+	hold, err := s.repo.VoidHold(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_void_hold", err)
+		return nil, err
+	}
+	return hold, nil
+}
+
+// ExpireHolds releases the holds that have outlived their TTL and reports how
+// many there were. It is run periodically by the hold sweeper.
+func (s *UserService) ExpireHolds(ctx context.Context) (int64, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.ExpireHolds")
+	defer span.End()
+
+	n, err := s.repo.ExpireHolds(ctx)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_expire_holds", err)
+		return 0, err
+	}
+	span.SetAttributes(attribute.Int64("holds.expired", n))
+	return n, nil
+}
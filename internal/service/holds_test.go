@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/repository"
+)
+
+// assertAvailable checks the balance of user id net of its holds.
+func assertAvailable(t *testing.T, s *UserService, id, want int64) {
+	t.Helper()
+	user, err := s.GetUser(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.AvailableBalance != want {
+		t.Errorf("user %d has %d available, want %d", id, user.AvailableBalance, want)
+	}
+}
+
+func TestCaptureHold(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		capture  int64
+		toCode   string
+		wantFrom int64
+		wantTo   int64
+	}{
+		{name: "in full", capture: 0, toCode: "USD", wantFrom: 600, wantTo: 400},
+		{name: "in part", capture: 150, toCode: "USD", wantFrom: 850, wantTo: 150},
+		{name: "converted", capture: 0, toCode: "EUR", wantFrom: 600, wantTo: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, Config{})
+			from := createUser(t, s, "USD", 1000)
+			to := createUser(t, s, tt.toCode, 0)
+
+			hold, err := s.CreateHold(ctx, from.Id, to.Id, 400, "USD")
+			if err != nil {
+				t.Fatalf("CreateHold: %v", err)
+			}
+			assertBalances(t, s, map[int64]int64{from.Id: 1000})
+			assertAvailable(t, s, from.Id, 600)
+
+			transfer, err := s.CaptureHold(ctx, hold.Id, tt.capture)
+			if err != nil {
+				t.Fatalf("CaptureHold: %v", err)
+			}
+			if got, _ := transfer.Credited(); got != tt.wantTo {
+				t.Errorf("credited %d, want %d", got, tt.wantTo)
+			}
+			// what was not captured is released
+			assertBalances(t, s, map[int64]int64{from.Id: tt.wantFrom, to.Id: tt.wantTo})
+			assertAvailable(t, s, from.Id, tt.wantFrom)
+
+			hold, err = s.GetHold(ctx, hold.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hold.Status != models.HoldStatusCaptured || hold.TransferId == nil || *hold.TransferId != transfer.Id {
+				t.Errorf("hold is %s with transfer %v, want captured into %d", hold.Status, hold.TransferId, transfer.Id)
+			}
+			if _, err := s.CaptureHold(ctx, hold.Id, 0); !errors.Is(err, repository.ErrHoldNotActive) {
+				t.Errorf("second capture: error %v, want %v", err, repository.ErrHoldNotActive)
+			}
+		})
+	}
+}
+
+func TestCreateHoldBeyondAvailable(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, Config{})
+	from := createUser(t, s, "USD", 1000)
+	to := createUser(t, s, "USD", 0)
+
+	hold, err := s.CreateHold(ctx, from.Id, to.Id, 700, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateHold(ctx, from.Id, to.Id, 301, "USD"); !errors.Is(err, repository.ErrInsufficientFunds) {
+		t.Fatalf("hold beyond the available balance: error %v, want %v", err, repository.ErrInsufficientFunds)
+	}
+	// held money cannot be sent elsewhere either
+	if _, err := s.TransferFunds(ctx, from.Id, to.Id, 301, "USD"); !errors.Is(err, repository.ErrInsufficientFunds) {
+		t.Fatalf("transfer of held money: error %v, want %v", err, repository.ErrInsufficientFunds)
+	}
+	if _, err := s.CaptureHold(ctx, hold.Id, 701); !errors.Is(err, repository.ErrValidation) {
+		t.Fatalf("capture beyond the hold: error %v, want %v", err, repository.ErrValidation)
+	}
+}
+
+func TestVoidHold(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, Config{})
+	from := createUser(t, s, "USD", 1000)
+	to := createUser(t, s, "USD", 0)
+
+	hold, err := s.CreateHold(ctx, from.Id, to.Id, 400, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	voided, err := s.VoidHold(ctx, hold.Id)
+	if err != nil {
+		t.Fatalf("VoidHold: %v", err)
+	}
+	if voided.Status != models.HoldStatusVoided {
+		t.Errorf("hold is %s, want %s", voided.Status, models.HoldStatusVoided)
+	}
+	assertBalances(t, s, map[int64]int64{from.Id: 1000, to.Id: 0})
+	assertAvailable(t, s, from.Id, 1000)
+
+	if _, err := s.CaptureHold(ctx, hold.Id, 0); !errors.Is(err, repository.ErrHoldNotActive) {
+		t.Errorf("capture of a voided hold: error %v, want %v", err, repository.ErrHoldNotActive)
+	}
+	if _, err := s.VoidHold(ctx, hold.Id); !errors.Is(err, repository.ErrHoldNotActive) {
+		t.Errorf("second void: error %v, want %v", err, repository.ErrHoldNotActive)
+	}
+}
+
+func TestExpireHolds(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, Config{HoldTTL: time.Millisecond})
+	from := createUser(t, s, "USD", 1000)
+	to := createUser(t, s, "USD", 0)
+
+	hold, err := s.CreateHold(ctx, from.Id, to.Id, 400, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	n, err := s.ExpireHolds(ctx)
+	if err != nil {
+		t.Fatalf("ExpireHolds: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expired %d holds, want 1", n)
+	}
+	assertAvailable(t, s, from.Id, 1000)
+
+	var notActive *repository.HoldNotActiveError
+	if _, err := s.CaptureHold(ctx, hold.Id, 0); !errors.As(err, &notActive) || notActive.Status != models.HoldStatusExpired {
+		t.Errorf("capture of an expired hold: error %v, want it expired", err)
+	}
+	if n, err := s.ExpireHolds(ctx); err != nil || n != 0 {
+		t.Errorf("second sweep expired %d holds, error %v; want none", n, err)
+	}
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lahaehae/crud_project/internal/currency"
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/repository"
+	"github.com/lahaehae/crud_project/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+type limitsInput struct {
+	MaxAmount     *int64 `json:"max_amount" validate:"omitnil,gte=0"`
+	DailyAmount   *int64 `json:"daily_amount" validate:"omitnil,gte=0"`
+	MonthlyAmount *int64 `json:"monthly_amount" validate:"omitnil,gte=0"`
+	HourlyCount   *int64 `json:"hourly_count" validate:"omitnil,gte=0"`
+}
+
+// effectiveLimits applies a user's overrides to the global limits.
+func effectiveLimits(global, overrides models.Limits) models.Limits {
+	pick := func(global, override *int64) *int64 {
+		switch {
+		case override == nil:
+			return global
+		case *override == 0:
+			return nil
+		}
+		return override
+	}
+	return models.Limits{
+		MaxAmount:     pick(global.MaxAmount, overrides.MaxAmount),
+		DailyAmount:   pick(global.DailyAmount, overrides.DailyAmount),
+		MonthlyAmount: pick(global.MonthlyAmount, overrides.MonthlyAmount),
+		HourlyCount:   pick(global.HourlyCount, overrides.HourlyCount),
+	}
+}
+
+// effectiveLimitsIn returns the limits in force for an account held in the
+// currency with code given its overrides. The global limits are configured
+// in LimitsCurrency, so the amount limits the account inherits are converted
+// at the current rate to stand for the same money whatever it is held in.
+func (s *UserService) effectiveLimitsIn(ctx context.Context, code string, overrides models.Limits) (models.Limits, error) {
+	global := s.cfg.Limits
+	inherits := func(global, override *int64) bool { return global != nil && override == nil }
+	if code == s.cfg.LimitsCurrency ||
+		!inherits(global.MaxAmount, overrides.MaxAmount) &&
+			!inherits(global.DailyAmount, overrides.DailyAmount) &&
+			!inherits(global.MonthlyAmount, overrides.MonthlyAmount) {
+		return effectiveLimits(global, overrides), nil
+	}
+
+	from, ok := currency.Lookup(s.cfg.LimitsCurrency)
+	if !ok {
+		return models.Limits{}, fmt.Errorf("limits are in unsupported currency %s", s.cfg.LimitsCurrency)
+	}
+	to, ok := currency.Lookup(code)
+	if !ok {
+		return models.Limits{}, fmt.Errorf("unsupported currency %s", code)
+	}
+	rate, err := s.rates.Rate(ctx, from.Code, to.Code)
+	if err != nil {
+		return models.Limits{}, err
+	}
+	convert := func(limit *int64) *int64 {
+		if limit == nil {
+			return nil
+		}
+		v, err := currency.Convert(*limit, from, to, rate)
+		if err != nil {
+			// no amount could reach it
+			v = math.MaxInt64
+		}
+		return &v
+	}
+	global.MaxAmount = convert(global.MaxAmount)
+	global.DailyAmount = convert(global.DailyAmount)
+	global.MonthlyAmount = convert(global.MonthlyAmount)
+	return effectiveLimits(global, overrides), nil
+}
+
+// accountLimits returns a user's limit overrides, the limits in force and
+// what the user has sent in the periods they count.
+func (s *UserService) accountLimits(ctx context.Context, userId int64) (*models.AccountLimits, error) {
+	user, err := s.repo.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := s.repo.GetLimits(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	effective, err := s.effectiveLimitsIn(ctx, user.Currency, *overrides)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := s.repo.TransferUsage(ctx, userId, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &models.AccountLimits{
+		UserId:    userId,
+		Currency:  user.Currency,
+		Overrides: *overrides,
+		Effective: effective,
+		Usage:     *usage,
+	}, nil
+}
+
+// checkLimit rejects a transfer of amount from account id that would break
+// limits given what it has sent already.
+func checkLimit(id int64, limits models.Limits, usage models.TransferUsage, amount int64) error {
+	exceeded := func(limit string, allowed, used, needed int64) error {
+		return &repository.LimitExceededError{AccountID: id, Limit: limit, Allowed: allowed, Used: used, Amount: needed}
+	}
+	switch {
+	case limits.MaxAmount != nil && amount > *limits.MaxAmount:
+		return exceeded(models.LimitMaxAmount, *limits.MaxAmount, 0, amount)
+	case limits.HourlyCount != nil && usage.HourCount+1 > *limits.HourlyCount:
+		return exceeded(models.LimitHourlyCount, *limits.HourlyCount, usage.HourCount, 1)
+	case limits.DailyAmount != nil && usage.DayAmount+amount > *limits.DailyAmount:
+		return exceeded(models.LimitDailyAmount, *limits.DailyAmount, usage.DayAmount, amount)
+	case limits.MonthlyAmount != nil && usage.MonthAmount+amount > *limits.MonthlyAmount:
+		return exceeded(models.LimitMonthlyAmount, *limits.MonthlyAmount, usage.MonthAmount, amount)
+	}
+	return nil
+}
+
+// limitTracker checks the transfers made in one transaction against their
+// senders' limits, counting every transfer it allows against the ones after
+// it. The senders' rows must be locked first, so that no concurrent transfer
+// can slip in between the check and the transfer.
+type limitTracker struct {
+	s       *UserService
+	now     time.Time
+	senders map[int64]*senderUsage
+}
+
+type senderUsage struct {
+	limits models.Limits
+	usage  models.TransferUsage
+}
+
+func (s *UserService) newLimitTracker() *limitTracker {
+	return &limitTracker{s: s, now: time.Now(), senders: map[int64]*senderUsage{}}
+}
+
+// allow checks a transfer of amount from fromId and counts it.
+func (t *limitTracker) allow(ctx context.Context, fromId, amount int64) error {
+	if err := t.check(ctx, fromId, amount); err != nil {
+		return err
+	}
+	t.count(fromId, amount)
+	return nil
+}
+
+// check checks a transfer of amount from fromId without counting it.
+func (t *limitTracker) check(ctx context.Context, fromId, amount int64) error {
+	su, ok := t.senders[fromId]
+	if !ok {
+		user, err := t.s.repo.GetUser(ctx, fromId)
+		if errors.Is(err, repository.ErrNotFound) {
+			return &repository.AccountNotFoundError{AccountID: fromId}
+		}
+		if err != nil {
+			return err
+		}
+		overrides, err := t.s.repo.GetLimits(ctx, fromId)
+		if err != nil {
+			return err
+		}
+		limits, err := t.s.effectiveLimitsIn(ctx, user.Currency, *overrides)
+		if err != nil {
+			return err
+		}
+		su = &senderUsage{limits: limits}
+		if l := su.limits; l.DailyAmount != nil || l.MonthlyAmount != nil || l.HourlyCount != nil {
+			usage, err := t.s.repo.TransferUsage(ctx, fromId, t.now)
+			if err != nil {
+				return err
+			}
+			su.usage = *usage
+		}
+		t.senders[fromId] = su
+	}
+
+	return checkLimit(fromId, su.limits, su.usage, amount)
+}
+
+// count counts a transfer of amount from fromId, which check must have
+// allowed, against the transfers after it.
+func (t *limitTracker) count(fromId, amount int64) {
+	su := t.senders[fromId]
+	su.usage.DayAmount += amount
+	su.usage.MonthAmount += amount
+	su.usage.HourCount++
+}
+
+// checkLimits locks the accounts of a transfer of amount from fromId to toId
+// and checks it against the sender's limits. It must run in the transaction
+// that makes the transfer.
+func (s *UserService) checkLimits(ctx context.Context, fromId, toId, amount int64) error {
+	if err := s.repo.LockAccounts(ctx, fromId, toId); err != nil {
+		return err
+	}
+	return s.newLimitTracker().allow(ctx, fromId, amount)
+}
+
+// GetLimits returns a user's limit overrides, the limits in force and what
+// the user has sent in the periods they count.
+func (s *UserService) GetLimits(ctx context.Context, userId int64) (*models.AccountLimits, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.GetLimits")
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "GetLimits"),
+			),
+		)
+	}
+
+	limits, err := s.accountLimits(ctx, userId)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "account_limits", err)
+		return nil, err
+	}
+	return limits, nil
+}
+
+// SetLimits replaces a user's limit overrides. A nil field inherits the
+// global limit and zero lifts it.
+func (s *UserService) SetLimits(ctx context.Context, userId int64, overrides models.Limits) (*models.AccountLimits, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.SetLimits")
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "SetLimits"),
+			),
+		)
+	}
+
+	input := limitsInput{
+		MaxAmount:     overrides.MaxAmount,
+		DailyAmount:   overrides.DailyAmount,
+		MonthlyAmount: overrides.MonthlyAmount,
+		HourlyCount:   overrides.HourlyCount,
+	}
+	if err := validateInput(input); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	if _, err := s.repo.SetLimits(ctx, userId, overrides); err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_set_limits", err)
+		return nil, err
+	}
+	limits, err := s.accountLimits(ctx, userId)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "account_limits", err)
+		return nil, err
+	}
+	return limits, nil
+}
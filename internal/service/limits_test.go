@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lahaehae/crud_project/internal/currency"
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/repository"
+)
+
+func ptr(v int64) *int64 { return &v }
+
+func TestEffectiveLimits(t *testing.T) {
+	global := models.Limits{MaxAmount: ptr(100), DailyAmount: ptr(500)}
+	got := effectiveLimits(global, models.Limits{MaxAmount: ptr(0), DailyAmount: ptr(50), HourlyCount: ptr(3)})
+
+	// zero lifts a limit, nil inherits it, anything else replaces it
+	if got.MaxAmount != nil {
+		t.Errorf("max_amount is %d, want lifted", *got.MaxAmount)
+	}
+	if got.DailyAmount == nil || *got.DailyAmount != 50 {
+		t.Errorf("daily_amount is %v, want 50", got.DailyAmount)
+	}
+	if got.MonthlyAmount != nil {
+		t.Errorf("monthly_amount is %d, want unlimited", *got.MonthlyAmount)
+	}
+	if got.HourlyCount == nil || *got.HourlyCount != 3 {
+		t.Errorf("hourly_count is %v, want 3", got.HourlyCount)
+	}
+}
+
+// assertLimitExceeded checks that err reports limit of account id.
+func assertLimitExceeded(t *testing.T, err error, id int64, limit string) {
+	t.Helper()
+	var limitErr *repository.LimitExceededError
+	if !errors.As(err, &limitErr) || limitErr.AccountID != id || limitErr.Limit != limit {
+		t.Fatalf("error %v, want %s of account %d exceeded", err, limit, id)
+	}
+}
+
+func TestTransferLimits(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		limits    models.Limits
+		overrides *models.Limits
+		amounts   []int64
+		// the first amount that is refused, or -1
+		refused   int
+		wantLimit string
+	}{
+		{name: "max amount", limits: models.Limits{MaxAmount: ptr(100)}, amounts: []int64{100, 101}, refused: 1, wantLimit: models.LimitMaxAmount},
+		{name: "daily amount", limits: models.Limits{DailyAmount: ptr(250)}, amounts: []int64{100, 150, 1}, refused: 2, wantLimit: models.LimitDailyAmount},
+		{name: "monthly amount", limits: models.Limits{MonthlyAmount: ptr(250)}, amounts: []int64{200, 51}, refused: 1, wantLimit: models.LimitMonthlyAmount},
+		{name: "hourly count", limits: models.Limits{HourlyCount: ptr(2)}, amounts: []int64{1, 1, 1}, refused: 2, wantLimit: models.LimitHourlyCount},
+		{name: "override lifts", limits: models.Limits{MaxAmount: ptr(100)}, overrides: &models.Limits{MaxAmount: ptr(0)}, amounts: []int64{500}, refused: -1},
+		{name: "override tightens", limits: models.Limits{MaxAmount: ptr(100)}, overrides: &models.Limits{MaxAmount: ptr(10)}, amounts: []int64{10, 11}, refused: 1, wantLimit: models.LimitMaxAmount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, Config{Limits: tt.limits})
+			from := createUser(t, s, "USD", 1000)
+			to := createUser(t, s, "USD", 0)
+			if tt.overrides != nil {
+				if _, err := s.SetLimits(ctx, from.Id, *tt.overrides); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			sent := int64(0)
+			for i, amount := range tt.amounts {
+				_, err := s.TransferFunds(ctx, from.Id, to.Id, amount, "USD")
+				if i != tt.refused {
+					if err != nil {
+						t.Fatalf("transfer %d of %d: %v", i, amount, err)
+					}
+					sent += amount
+					continue
+				}
+				assertLimitExceeded(t, err, from.Id, tt.wantLimit)
+				break
+			}
+			assertBalances(t, s, map[int64]int64{from.Id: 1000 - sent, to.Id: sent})
+		})
+	}
+}
+
+func TestCaptureHoldLimits(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, Config{Limits: models.Limits{DailyAmount: ptr(300)}})
+	from := createUser(t, s, "USD", 1000)
+	to := createUser(t, s, "USD", 0)
+
+	// a hold itself moves nothing, but capturing it is a transfer
+	hold, err := s.CreateHold(ctx, from.Id, to.Id, 1000, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.CaptureHold(ctx, hold.Id, 0)
+	assertLimitExceeded(t, err, from.Id, models.LimitDailyAmount)
+	if _, err := s.CaptureHold(ctx, hold.Id, 300); err != nil {
+		t.Fatalf("capture within the limit: %v", err)
+	}
+	assertBalances(t, s, map[int64]int64{from.Id: 700, to.Id: 300})
+
+	// the capture counts against what is left for the day
+	_, err = s.TransferFunds(ctx, from.Id, to.Id, 1, "USD")
+	assertLimitExceeded(t, err, from.Id, models.LimitDailyAmount)
+}
+
+func TestReverseTransferLimits(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, Config{Limits: models.Limits{MaxAmount: ptr(500)}})
+	from := createUser(t, s, "USD", 1000)
+	to := createUser(t, s, "USD", 0)
+	if _, err := s.SetLimits(ctx, from.Id, models.Limits{MaxAmount: ptr(0)}); err != nil {
+		t.Fatal(err)
+	}
+
+	transfer, err := s.TransferFunds(ctx, from.Id, to.Id, 800, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the refund leaves the recipient's account, which is limited
+	_, err = s.ReverseTransfer(ctx, transfer.Id, 0)
+	assertLimitExceeded(t, err, to.Id, models.LimitMaxAmount)
+	if _, err := s.ReverseTransfer(ctx, transfer.Id, 500); err != nil {
+		t.Fatalf("refund within the limit: %v", err)
+	}
+	assertBalances(t, s, map[int64]int64{from.Id: 700, to.Id: 300})
+}
+
+func TestBatchLimits(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, Config{Limits: models.Limits{DailyAmount: ptr(500)}})
+	a := createUser(t, s, "USD", 1000)
+	b := createUser(t, s, "USD", 0)
+	order := func(amount int64) models.TransferOrder {
+		return models.TransferOrder{FromId: a.Id, ToId: b.Id, Amount: amount, Currency: "USD"}
+	}
+
+	// each order counts against the limit for the ones after it
+	_, err := s.TransferBatch(ctx, BatchModeAtomic, []models.TransferOrder{order(300), order(300)})
+	var itemErr *repository.BatchItemError
+	if !errors.As(err, &itemErr) || itemErr.Index != 1 {
+		t.Fatalf("atomic batch: error %v, want transfer 1 to fail", err)
+	}
+	assertLimitExceeded(t, err, a.Id, models.LimitDailyAmount)
+	assertBalances(t, s, map[int64]int64{a.Id: 1000})
+
+	results, err := s.TransferBatch(ctx, BatchModeBestEffort, []models.TransferOrder{order(300), order(300), order(200)})
+	if err != nil {
+		t.Fatalf("best-effort batch: %v", err)
+	}
+	if results[0].Err != nil || results[2].Err != nil {
+		t.Errorf("transfers within the limit failed: %v, %v", results[0].Err, results[2].Err)
+	}
+	assertLimitExceeded(t, results[1].Err, a.Id, models.LimitDailyAmount)
+	assertBalances(t, s, map[int64]int64{a.Id: 500, b.Id: 500})
+}
+
+func TestBatchLimitsCountMadeOrders(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, Config{Limits: models.Limits{DailyAmount: ptr(1000)}, LimitsCurrency: "USD"})
+	a := createUser(t, s, "USD", 500)
+	b := createUser(t, s, "USD", 0)
+	// the limits cannot be priced in roubles, which fails only its order
+	rub := createUser(t, s, "RUB", 500)
+	rubTo := createUser(t, s, "RUB", 0)
+
+	results, err := s.TransferBatch(ctx, BatchModeBestEffort, []models.TransferOrder{
+		{FromId: a.Id, ToId: b.Id, Amount: 800, Currency: "USD"},
+		{FromId: rub.Id, ToId: rubTo.Id, Amount: 100, Currency: "RUB"},
+		{FromId: a.Id, ToId: b.Id, Amount: 500, Currency: "USD"},
+	})
+	if err != nil {
+		t.Fatalf("TransferBatch: %v", err)
+	}
+	if !errors.Is(results[0].Err, repository.ErrInsufficientFunds) {
+		t.Errorf("transfer 0: error %v, want %v", results[0].Err, repository.ErrInsufficientFunds)
+	}
+	if !errors.Is(results[1].Err, currency.ErrNoRate) {
+		t.Errorf("transfer 1: error %v, want %v", results[1].Err, currency.ErrNoRate)
+	}
+	// had the failed order counted, this one would be over the daily limit
+	if results[2].Err != nil || results[2].Transfer == nil {
+		t.Errorf("transfer 2 failed: %v", results[2].Err)
+	}
+	assertBalances(t, s, map[int64]int64{a.Id: 0, b.Id: 500, rub.Id: 500, rubTo.Id: 0})
+}
+
+func TestLimitsInAccountCurrency(t *testing.T) {
+	ctx := context.Background()
+	// the global limit is 10 dollars, which buys 5 euros
+	s := newTestService(t, Config{Limits: models.Limits{MaxAmount: ptr(1000)}, LimitsCurrency: "USD"})
+	eur := createUser(t, s, "EUR", 10000)
+	eurTo := createUser(t, s, "EUR", 0)
+
+	limits, err := s.GetLimits(ctx, eur.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.Currency != "EUR" || limits.Effective.MaxAmount == nil || *limits.Effective.MaxAmount != 500 {
+		t.Fatalf("effective limits are %+v in %s, want a max_amount of 500 EUR", limits.Effective, limits.Currency)
+	}
+	if _, err := s.TransferFunds(ctx, eur.Id, eurTo.Id, 500, "EUR"); err != nil {
+		t.Fatalf("transfer within the converted limit: %v", err)
+	}
+	_, err = s.TransferFunds(ctx, eur.Id, eurTo.Id, 501, "EUR")
+	assertLimitExceeded(t, err, eur.Id, models.LimitMaxAmount)
+
+	// without a rate the inherited limit cannot be enforced, so nothing goes
+	rub := createUser(t, s, "RUB", 10000)
+	rubTo := createUser(t, s, "RUB", 0)
+	if _, err := s.TransferFunds(ctx, rub.Id, rubTo.Id, 1, "RUB"); !errors.Is(err, currency.ErrNoRate) {
+		t.Fatalf("transfer without a rate for the limits: error %v, want %v", err, currency.ErrNoRate)
+	}
+	// unless the account's own limits replace it
+	if _, err := s.SetLimits(ctx, rub.Id, models.Limits{MaxAmount: ptr(5000)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.TransferFunds(ctx, rub.Id, rubTo.Id, 5000, "RUB"); err != nil {
+		t.Fatalf("transfer within the account's own limit: %v", err)
+	}
+}
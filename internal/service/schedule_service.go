@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lahaehae/crud_project/internal/cron"
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/repository"
+	"github.com/lahaehae/crud_project/internal/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MinScheduleInterval is the shortest repeat interval a schedule may have.
+const MinScheduleInterval = time.Minute
+
+// ScheduleService manages scheduled transfers. The transfers themselves are
+// made by the scheduler worker through UserService.TransferFunds.
+type ScheduleService struct {
+	store  repository.ScheduleStore
+	users  repository.UserRepo
+	cfg    Config
+	meter  metric.Meter
+	tracer trace.Tracer
+}
+
+// NewScheduleService creates the service. users is only read, to check the
+// accounts a new schedule names.
+func NewScheduleService(store repository.ScheduleStore, users repository.UserRepo, cfg Config) *ScheduleService {
+	if cfg.MaxTransferAmount == 0 {
+		cfg.MaxTransferAmount = DefaultMaxTransferAmount
+	}
+	return &ScheduleService{
+		store:  store,
+		users:  users,
+		cfg:    cfg,
+		meter:  otel.Meter("service"),
+		tracer: otel.Tracer("service"),
+	}
+}
+
+// CreateSchedule validates the client-settable fields of st, that is the
+// parties, amount and currency, one of RunAt, Cron and Interval, and the
+// optional StartAt, EndAt and MaxRuns, and stores it as an active schedule.
+// StartAt defaults to now.
+func (s *ScheduleService) CreateSchedule(ctx context.Context, st models.ScheduledTransfer) (*models.ScheduledTransfer, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.CreateSchedule")
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "CreateSchedule"),
+			),
+		)
+	}
+
+	now := time.Now().UTC()
+	if st.StartAt.IsZero() {
+		st.StartAt = now
+	}
+	if err := s.validateSchedule(st); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	if err := s.checkAccounts(ctx, st); err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "check_schedule_accounts", err)
+		return nil, err
+	}
+
+	// the first occurrence may fall on StartAt itself
+	next, ok := NextRun(&st, st.StartAt.Add(-time.Nanosecond))
+	if !ok {
+		// run_at has been checked against start_at already, so either the
+		// end bound removed the first occurrence or the cron never matches
+		field := repository.FieldError{Field: "cron", Message: "never matches after start_at"}
+		unbounded := st
+		unbounded.EndAt = nil
+		if _, ok := NextRun(&unbounded, st.StartAt.Add(-time.Nanosecond)); ok {
+			field = repository.FieldError{Field: "end_at", Message: "leaves no occurrence to run"}
+		}
+		err := &repository.ValidationError{Fields: []repository.FieldError{field}}
+		span.RecordError(err)
+		return nil, err
+	}
+	st.Status = models.ScheduleStatusActive
+	st.NextRunAt = &next
+
+	created, err := s.store.CreateSchedule(ctx, st)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_create_schedule", err)
+		return nil, err
+	}
+	return created, nil
+}
+
+type scheduleInput struct {
+	FromId   int64  `json:"from_id" validate:"required,gt=0"`
+	ToId     int64  `json:"to_id" validate:"required,gt=0"`
+	Amount   int64  `json:"amount" validate:"gt=0"`
+	Currency string `json:"currency" validate:"required"`
+	MaxRuns  *int   `json:"max_runs" validate:"omitnil,gt=0"`
+}
+
+func (s *ScheduleService) validateSchedule(st models.ScheduledTransfer) error {
+	var extra []repository.FieldError
+	if st.FromId != 0 && st.FromId == st.ToId {
+		extra = append(extra, repository.FieldError{Field: "to_id", Message: "must differ from from_id"})
+	}
+	if st.Amount > s.cfg.MaxTransferAmount {
+		extra = append(extra, repository.FieldError{Field: "amount", Message: fmt.Sprintf("must be at most %d", s.cfg.MaxTransferAmount)})
+	}
+	if st.Currency != "" {
+		_, unknown := lookupCurrency(st.Currency)
+		extra = append(extra, unknown...)
+	}
+
+	kinds := 0
+	if st.RunAt != nil {
+		kinds++
+		// StartAt defaults to now, so this also rejects a run_at in the past
+		if st.RunAt.Before(st.StartAt) {
+			extra = append(extra, repository.FieldError{Field: "run_at", Message: "must not be before start_at or in the past"})
+		}
+	}
+	if st.Cron != "" {
+		kinds++
+		if _, err := cron.Parse(st.Cron); err != nil {
+			extra = append(extra, repository.FieldError{Field: "cron", Message: err.Error()})
+		}
+	}
+	if st.Interval != "" {
+		kinds++
+		d, err := time.ParseDuration(st.Interval)
+		if err != nil || d < MinScheduleInterval {
+			extra = append(extra, repository.FieldError{Field: "interval", Message: "must be a duration of at least " + MinScheduleInterval.String()})
+		}
+	}
+	if kinds != 1 {
+		extra = append(extra, repository.FieldError{Field: "run_at", Message: "exactly one of run_at, cron and interval is required"})
+	}
+	if st.EndAt != nil && !st.EndAt.After(st.StartAt) {
+		extra = append(extra, repository.FieldError{Field: "end_at", Message: "must be after start_at"})
+	}
+
+	return validateInput(scheduleInput{
+		FromId:   st.FromId,
+		ToId:     st.ToId,
+		Amount:   st.Amount,
+		Currency: st.Currency,
+		MaxRuns:  st.MaxRuns,
+	}, extra...)
+}
+
+// checkAccounts rejects a schedule that could never run: one naming a
+// missing account or a currency other than the sender's. Whether the
+// recipient can be paid is only known when the transfer is made.
+func (s *ScheduleService) checkAccounts(ctx context.Context, st models.ScheduledTransfer) error {
+	for _, id := range []int64{st.FromId, st.ToId} {
+		user, err := s.users.GetUser(ctx, id)
+		if errors.Is(err, repository.ErrNotFound) {
+			return &repository.AccountNotFoundError{AccountID: id}
+		}
+		if err != nil {
+			return err
+		}
+		if id == st.FromId && user.Currency != st.Currency {
+			return &repository.CurrencyMismatchError{AccountID: id, Currency: user.Currency, Expected: st.Currency}
+		}
+	}
+	return nil
+}
+
+// NextRun returns the first occurrence of st strictly after after, or false
+// when st has none left because it ran its only time, reached EndAt or
+// reached MaxRuns.
+func NextRun(st *models.ScheduledTransfer, after time.Time) (time.Time, bool) {
+	if st.MaxRuns != nil && st.RunCount >= *st.MaxRuns {
+		return time.Time{}, false
+	}
+
+	var next time.Time
+	switch {
+	case st.RunAt != nil:
+		if st.RunCount > 0 || !st.RunAt.After(after) {
+			return time.Time{}, false
+		}
+		next = *st.RunAt
+	case st.Interval != "":
+		d, err := time.ParseDuration(st.Interval)
+		if err != nil || d <= 0 {
+			return time.Time{}, false
+		}
+		next = st.StartAt
+		if !next.After(after) {
+			next = next.Add(d * (after.Sub(next)/d + 1))
+		}
+	case st.Cron != "":
+		sched, err := cron.Parse(st.Cron)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if after.Before(st.StartAt) {
+			after = st.StartAt.Add(-time.Nanosecond)
+		}
+		if next = sched.Next(after); next.IsZero() {
+			return time.Time{}, false
+		}
+	default:
+		return time.Time{}, false
+	}
+
+	if st.EndAt != nil && next.After(*st.EndAt) {
+		return time.Time{}, false
+	}
+	return next.UTC(), true
+}
+
+func (s *ScheduleService) GetSchedule(ctx context.Context, id int64) (*models.ScheduledTransfer, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.GetSchedule")
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "GetSchedule"),
+			),
+		)
+	}
+
+	st, err := s.store.GetSchedule(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_get_schedule", err)
+		return nil, err
+	}
+	return st, nil
+}
+
+// CancelSchedule stops an active schedule from running again.
+func (s *ScheduleService) CancelSchedule(ctx context.Context, id int64) (*models.ScheduledTransfer, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.CancelSchedule")
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "CancelSchedule"),
+			),
+		)
+	}
+
+	st, err := s.store.CancelSchedule(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_cancel_schedule", err)
+		return nil, err
+	}
+	return st, nil
+}
+
+// ListScheduleRuns returns up to limit of the latest runs of a schedule,
+// newest first.
+func (s *ScheduleService) ListScheduleRuns(ctx context.Context, id int64, limit int) ([]models.ScheduledTransferRun, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.ListScheduleRuns")
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "ListScheduleRuns"),
+			),
+		)
+	}
+
+	runs, err := s.store.ListScheduleRuns(ctx, id, limit)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_list_schedule_runs", err)
+		return nil, err
+	}
+	return runs, nil
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lahaehae/crud_project/internal/currency"
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/repository"
+	"github.com/lahaehae/crud_project/internal/telemetry"
+)
+
+func TestMain(m *testing.M) {
+	// some error paths record metrics unconditionally; without an exporter
+	// the instruments are no-ops
+	telemetry.InitMetrics()
+	os.Exit(m.Run())
+}
+
+// testRates prices transfers between the test accounts: one dollar buys
+// half a euro.
+const testRates = "USD/EUR=0.5"
+
+// newTestService returns a UserService backed by a fresh in-memory
+// repository, which also manages its transactions.
+func newTestService(t *testing.T, cfg Config) *UserService {
+	t.Helper()
+	rates, err := currency.ParseStaticRates(testRates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.NewMemoryUserRepository()
+	return NewUserService(repo, repo, rates, cfg)
+}
+
+var testUsers atomic.Int64
+
+// createUser opens an account in code holding balance.
+func createUser(t *testing.T, s *UserService, code string, balance int64) *models.User {
+	t.Helper()
+	n := testUsers.Add(1)
+	user, err := s.CreateUser(context.Background(), fmt.Sprintf("User %d", n), fmt.Sprintf("user%d@example.com", n), code, balance)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+// assertBalances checks the balances of the accounts in want.
+func assertBalances(t *testing.T, s *UserService, want map[int64]int64) {
+	t.Helper()
+	for id, balance := range want {
+		user, err := s.GetUser(context.Background(), id)
+		if err != nil {
+			t.Fatalf("get user %d: %v", id, err)
+		}
+		if user.Balance != balance {
+			t.Errorf("user %d has balance %d, want %d", id, user.Balance, balance)
+		}
+	}
+}
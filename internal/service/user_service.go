@@ -2,9 +2,12 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/lahaehae/crud_project/internal/currency"
 	"github.com/lahaehae/crud_project/internal/models"
 	"github.com/lahaehae/crud_project/internal/repository"
 	"github.com/lahaehae/crud_project/internal/telemetry"
@@ -14,21 +17,55 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
+// Config holds the business rules enforced by UserService.
+type Config struct {
+	// MaxTransferAmount caps a single transfer; zero means DefaultMaxTransferAmount.
+	MaxTransferAmount int64
+	// HoldTTL is how long a hold reserves funds; zero means DefaultHoldTTL.
+	HoldTTL time.Duration
+	// Limits are the transfer limits of users without overrides; nil fields
+	// are unlimited.
+	Limits models.Limits
+	// LimitsCurrency is the currency the amounts of Limits are in; empty
+	// means currency.Default.
+	LimitsCurrency string
+}
+
 type UserService struct {	
-	repo repository.UserRepository
+	repo  repository.UserRepo
+	tx    repository.TxManager
+	rates currency.RateProvider
+	cfg   Config
 	meter metric.Meter;
 	tracer trace.Tracer;
 }
 
-func NewUserService(repo repository.UserRepository) *UserService {
+// NewUserService creates the service. tx must manage transactions for the
+// same store as repo; rates prices transfers between accounts in different
+// currencies.
+func NewUserService(repo repository.UserRepo, tx repository.TxManager, rates currency.RateProvider, cfg Config) *UserService {
+	if cfg.MaxTransferAmount == 0 {
+		cfg.MaxTransferAmount = DefaultMaxTransferAmount
+	}
+	if cfg.HoldTTL == 0 {
+		cfg.HoldTTL = DefaultHoldTTL
+	}
+	if cfg.LimitsCurrency == "" {
+		cfg.LimitsCurrency = currency.Default
+	}
 	return &UserService{
-		repo: repo,
+		repo:  repo,
+		tx:    tx,
+		rates: rates,
+		cfg:   cfg,
 		meter: otel.Meter("service"),
 		tracer: otel.Tracer("service"),
 	}
 }
 
-func (s *UserService) CreateUser(ctx context.Context, name, email string, balance int64 ) (*models.User, error) {
+// CreateUser opens an account in the currency with code, or in
+// currency.Default when code is empty.
+func (s *UserService) CreateUser(ctx context.Context, name, email, code string, balance int64 ) (*models.User, error) {
 	ctx, span := s.tracer.Start(ctx, "Service.CreateUser")
 	defer span.End()
 
@@ -41,11 +78,20 @@ func (s *UserService) CreateUser(ctx context.Context, name, email string, balanc
 			),
 		)
 	}
-	user, err := s.repo.CreateUser(ctx, name, email, balance)
+
+	email = normalizeEmail(email)
+	if code == "" {
+		code = currency.Default
+	}
+	cur, extra := lookupCurrency(code)
+	if err := validateInput(userInput{Name: name, Email: email, Currency: code, Balance: balance}, extra...); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	user, err := s.repo.CreateUser(ctx, name, email, cur, balance)
 	if err != nil {
 		span.RecordError(err)
 		telemetry.ErrorCounter.Add(ctx, 1, metric.WithAttributes(
-			attribute.Int64("userId: ", int64(user.Id)),
 			attribute.String("error.type", fmt.Sprintf("%T", err)),
 			attribute.String("error.msg", err.Error()),
 			))
@@ -60,6 +106,10 @@ func (s *UserService) CreateUser(ctx context.Context, name, email string, balanc
 		Name:  user.Name,
 		Email: user.Email,
 		Balance: user.Balance,
+		AvailableBalance: user.AvailableBalance,
+		Currency: user.Currency,
+		CurrencyExponent: user.CurrencyExponent,
+		Version: user.Version,
 	}, nil
 }
 
@@ -96,10 +146,70 @@ func (s *UserService) GetUser(ctx context.Context, id int64) (*models.User, erro
 		Name:  user.Name,
 		Email: user.Email,
 		Balance: user.Balance,
+		AvailableBalance: user.AvailableBalance,
+		Currency: user.Currency,
+		CurrencyExponent: user.CurrencyExponent,
+		Version: user.Version,
 	}, nil
 }
 
-func (s *UserService) UpdateUser(ctx context.Context, id int64, name, email string, balance int64) (*models.User, error) {
+func (s *UserService) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.GetUserByEmail")
+	defer span.End()
+
+	start := time.Now()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "GetUserByEmail"),
+			),
+		)
+	}
+
+	user, err := s.repo.GetUserByEmail(ctx, normalizeEmail(email))
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_get_user_by_email", err)
+		return nil, err
+	}
+
+	if telemetry.LatencyRecorder != nil {
+		telemetry.LatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return user, nil
+}
+
+func (s *UserService) ListUsers(ctx context.Context, filter models.UserFilter) (*models.UserPage, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.ListUsers")
+	defer span.End()
+
+	start := time.Now()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "ListUsers"),
+			),
+		)
+	}
+
+	page, err := s.repo.ListUsers(ctx, filter)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_list_users", err)
+		return nil, err
+	}
+
+	if telemetry.LatencyRecorder != nil {
+		telemetry.LatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return page, nil
+}
+
+// UpdateUser replaces the user's profile; balances change only through
+// deposits, withdrawals and transfers.
+func (s *UserService) UpdateUser(ctx context.Context, id int64, name, email string, version int64) (*models.User, error) {
 	ctx, span := s.tracer.Start(ctx, "Service.UpdateUser")
 	defer span.End()
 
@@ -111,7 +221,13 @@ func (s *UserService) UpdateUser(ctx context.Context, id int64, name, email stri
 		)
 	}
 
-	user, err := s.repo.UpdateUser(ctx, id, name, email, balance)
+	email = normalizeEmail(email)
+	if err := validateInput(profileInput{Name: name, Email: email}); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	user, err := s.repo.UpdateUser(ctx, id, name, email, version)
 	if err != nil {
 		span.RecordError(err)
 		telemetry.RecordErrorMetric(ctx, "repo_update_user", err)
@@ -122,10 +238,48 @@ func (s *UserService) UpdateUser(ctx context.Context, id int64, name, email stri
 		Name:  user.Name,
 		Email: user.Email,
 		Balance: user.Balance,
+		AvailableBalance: user.AvailableBalance,
+		Currency: user.Currency,
+		CurrencyExponent: user.CurrencyExponent,
+		Version: user.Version,
 	}, nil
 }
 
-func (s *UserService) TransferFunds(ctx context.Context, fromId, toId, balance int64) (*models.User, error) {
+func (s *UserService) PatchUser(ctx context.Context, id int64, patch models.UserPatch, version int64) (*models.User, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.PatchUser")
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "PatchUser"),
+			),
+		)
+	}
+
+	if patch.Email != nil {
+		email := normalizeEmail(*patch.Email)
+		patch.Email = &email
+	}
+	if err := validateInput(userPatchInput{Name: patch.Name, Email: patch.Email}); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	user, err := s.repo.PatchUser(ctx, id, patch, version)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_patch_user", err)
+		return nil, err
+	}
+	return user, nil
+}
+
+// TransferFunds moves balance, given in the sender's currency code, between
+// two accounts. When the recipient holds another currency the amount is
+// converted at the rate quoted by the service's RateProvider. The transfer
+// must keep within the sender's limits.
+func (s *UserService) TransferFunds(ctx context.Context, fromId, toId, balance int64, code string) (*models.Transfer, error) {
 	ctx, span := s.tracer.Start(ctx, "Service.TransferFunds")
 	defer span.End()
 	
@@ -136,28 +290,98 @@ func (s *UserService) TransferFunds(ctx context.Context, fromId, toId, balance i
 			),
 		)
 	}
-	user, err := s.repo.TransferFunds(ctx, fromId, toId, balance)
+
+	if err := s.validateTransfer(fromId, toId, balance, code); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	quote, err := s.quote(ctx, fromId, toId, code)
 	if err != nil {
 		span.RecordError(err)
-		telemetry.RecordErrorMetric(ctx, "repo_transfer_funds", err)
+		telemetry.RecordErrorMetric(ctx, "quote_transfer", err)
 		return nil, err
 	}
-	user1, err := s.repo.GetUser(ctx, toId)
+
+	var transfer *models.Transfer
+	err = s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.checkLimits(ctx, fromId, toId, balance); err != nil {
+			return err
+		}
+		var err error
+		if quote == nil {
+			transfer, err = s.repo.TransferFunds(ctx, fromId, toId, balance, code)
+		} else {
+			transfer, err = s.repo.ExchangeFunds(ctx, fromId, toId, balance, *quote)
+		}
+		return err
+	})
 	if err != nil {
 		span.RecordError(err)
-		telemetry.RecordErrorMetric(ctx, "repo_get_user", err)
+		telemetry.RecordErrorMetric(ctx, "repo_transfer_funds", err)
 		return nil, err
 	}
-	return &models.User{
-		Id:      user.Id,
-		Name:    user1.Name,
-		Email:   user1.Email,
-		Balance: user.Balance,
-	}, nil
+	return transfer, nil
+}
+
+// validateTransfer checks the fields of a transfer request.
+func (s *UserService) validateTransfer(fromId, toId, balance int64, code string) error {
+	var extra []repository.FieldError
+	if fromId != 0 && fromId == toId {
+		extra = append(extra, repository.FieldError{Field: "to_id", Message: "must differ from from_id"})
+	}
+	if balance > s.cfg.MaxTransferAmount {
+		extra = append(extra, repository.FieldError{Field: "balance", Message: fmt.Sprintf("must be at most %d", s.cfg.MaxTransferAmount)})
+	}
+	if code != "" {
+		_, unknown := lookupCurrency(code)
+		extra = append(extra, unknown...)
+	}
+	return validateInput(transferInput{FromId: fromId, ToId: toId, Amount: balance, Currency: code}, extra...)
 }
 
+// quote prices a transfer of code from fromId to toId, or returns nil when
+// both accounts hold code. An account's currency never changes, so it is
+// safe to read it before the transfer locks the rows; the repository checks
+// it again under the lock.
+func (s *UserService) quote(ctx context.Context, fromId, toId int64, code string) (*currency.Quote, error) {
+	var users [2]*models.User
+	for i, id := range []int64{fromId, toId} {
+		user, err := s.repo.GetUser(ctx, id)
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, &repository.AccountNotFoundError{AccountID: id}
+		}
+		if err != nil {
+			return nil, err
+		}
+		users[i] = user
+	}
+	from, to := users[0], users[1]
+	if from.Currency != code {
+		return nil, &repository.CurrencyMismatchError{AccountID: fromId, Currency: from.Currency, Expected: code}
+	}
+	if to.Currency == code {
+		return nil, nil
+	}
+
+	fromCur, _ := currency.Lookup(from.Currency)
+	toCur, ok := currency.Lookup(to.Currency)
+	if !ok {
+		return nil, fmt.Errorf("account %d holds unsupported currency %s", toId, to.Currency)
+	}
+	rate, err := s.rates.Rate(ctx, fromCur.Code, toCur.Code)
+	if err != nil {
+		return nil, err
+	}
+	quote, err := currency.NewQuote(fromCur, toCur, rate)
+	if err != nil {
+		return nil, err
+	}
+	return &quote, nil
+}
 
-func (s *UserService) DeleteUser(ctx context.Context, id int64)  error {
+
+func (s *UserService) DeleteUser(ctx context.Context, id int64, version int64)  error {
 	ctx, span := s.tracer.Start(ctx, "Service.DeleteUser")
 	defer span.End()
 
@@ -169,11 +393,151 @@ func (s *UserService) DeleteUser(ctx context.Context, id int64)  error {
 		)
 	}
 
-	err := s.repo.DeleteUser(ctx, id)
+	err := s.repo.DeleteUser(ctx, id, version)
 	if err != nil {
 		span.RecordError(err)
 		telemetry.RecordErrorMetric(ctx, "repo_delete_user", err)
 		return err
 	}
 	return err
-}
\ No newline at end of file
+}
+func (s *UserService) GetTransfer(ctx context.Context, id int64) (*models.Transfer, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.GetTransfer")
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "GetTransfer"),
+			),
+		)
+	}
+
+	transfer, err := s.repo.GetTransfer(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_get_transfer", err)
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// ListTransfers returns a page of the transfers sent or received by a user.
+func (s *UserService) ListTransfers(ctx context.Context, filter models.TransferFilter) (*models.TransferPage, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.ListTransfers")
+	defer span.End()
+
+	start := time.Now()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "ListTransfers"),
+			),
+		)
+	}
+
+	page, err := s.repo.ListTransfers(ctx, filter)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_list_transfers", err)
+		return nil, err
+	}
+
+	if telemetry.LatencyRecorder != nil {
+		telemetry.LatencyRecorder.Record(ctx, time.Since(start).Seconds())
+	}
+	return page, nil
+}
+
+// ReverseTransfer refunds amount of a transfer, or all that is left of it when
+// amount is zero. The refund counts against the limits of the account that
+// pays it back.
+func (s *UserService) ReverseTransfer(ctx context.Context, id, amount int64) (*models.Transfer, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.ReverseTransfer")
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", "ReverseTransfer"),
+			),
+		)
+	}
+
+	if err := validateInput(reversalInput{Amount: amount}); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	var reversal *models.Transfer
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		// the refund is a transfer out of the original recipient's account
+		// and counts against its limits
+		original, err := s.repo.GetTransfer(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := s.repo.LockAccounts(ctx, original.FromId, original.ToId); err != nil {
+			return err
+		}
+		// with the accounts locked no other refund of the transfer can run
+		if original, err = s.repo.GetTransfer(ctx, id); err != nil {
+			return err
+		}
+		planned, err := repository.PlanReversal(original, amount)
+		if err != nil {
+			return err
+		}
+		if err := s.newLimitTracker().allow(ctx, planned.FromId, planned.Amount); err != nil {
+			return err
+		}
+		reversal, err = s.repo.ReverseTransfer(ctx, id, amount)
+		return err
+	})
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_reverse_transfer", err)
+		return nil, err
+	}
+	return reversal, nil
+}
+
+// Deposit credits amount to a user's balance.
+func (s *UserService) Deposit(ctx context.Context, id, amount int64) (*models.User, error) {
+	return s.cashMovement(ctx, "Deposit", s.repo.Deposit, id, amount)
+}
+
+// Withdraw debits amount from a user's balance.
+func (s *UserService) Withdraw(ctx context.Context, id, amount int64) (*models.User, error) {
+	return s.cashMovement(ctx, "Withdraw", s.repo.Withdraw, id, amount)
+}
+
+func (s *UserService) cashMovement(ctx context.Context, method string, move func(ctx context.Context, id, amount int64) (*models.User, error), id, amount int64) (*models.User, error) {
+	ctx, span := s.tracer.Start(ctx, "Service."+method)
+	defer span.End()
+
+	if telemetry.RequestsCounter != nil {
+		telemetry.RequestsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("method: ", method),
+			),
+		)
+	}
+
+	var extra []repository.FieldError
+	if amount > s.cfg.MaxTransferAmount {
+		extra = append(extra, repository.FieldError{Field: "amount", Message: fmt.Sprintf("must be at most %d", s.cfg.MaxTransferAmount)})
+	}
+	if err := validateInput(amountInput{Amount: amount}, extra...); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	user, err := move(ctx, id, amount)
+	if err != nil {
+		span.RecordError(err)
+		telemetry.RecordErrorMetric(ctx, "repo_"+strings.ToLower(method), err)
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/lahaehae/crud_project/internal/currency"
+	"github.com/lahaehae/crud_project/internal/models"
+	"github.com/lahaehae/crud_project/internal/repository"
+)
+
+func TestTransferFunds(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		amount   int64
+		code     string
+		toCode   string
+		wantErr  error
+		wantFrom int64
+		wantTo   int64
+	}{
+		{name: "same currency", amount: 300, code: "USD", toCode: "USD", wantFrom: 700, wantTo: 300},
+		{name: "whole balance", amount: 1000, code: "USD", toCode: "USD", wantFrom: 0, wantTo: 1000},
+		{name: "converted", amount: 300, code: "USD", toCode: "EUR", wantFrom: 700, wantTo: 150},
+		{name: "insufficient funds", amount: 1001, code: "USD", toCode: "USD", wantErr: repository.ErrInsufficientFunds, wantFrom: 1000},
+		{name: "currency of another account", amount: 300, code: "EUR", toCode: "EUR", wantErr: repository.ErrCurrencyMismatch, wantFrom: 1000},
+		{name: "no rate", amount: 300, code: "USD", toCode: "RUB", wantErr: currency.ErrNoRate, wantFrom: 1000},
+		{name: "zero amount", amount: 0, code: "USD", toCode: "USD", wantErr: repository.ErrValidation, wantFrom: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, Config{})
+			from := createUser(t, s, "USD", 1000)
+			to := createUser(t, s, tt.toCode, 0)
+
+			transfer, err := s.TransferFunds(ctx, from.Id, to.Id, tt.amount, tt.code)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("TransferFunds: error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if got, _ := transfer.Credited(); got != tt.wantTo {
+					t.Errorf("credited %d, want %d", got, tt.wantTo)
+				}
+			}
+			assertBalances(t, s, map[int64]int64{from.Id: tt.wantFrom, to.Id: tt.wantTo})
+		})
+	}
+}
+
+func TestTransferFundsMissingAccount(t *testing.T) {
+	s := newTestService(t, Config{})
+	from := createUser(t, s, "USD", 1000)
+
+	_, err := s.TransferFunds(context.Background(), from.Id, from.Id+100, 100, "USD")
+	var notFound *repository.AccountNotFoundError
+	if !errors.As(err, &notFound) || notFound.AccountID != from.Id+100 {
+		t.Fatalf("TransferFunds: error %v, want account %d not found", err, from.Id+100)
+	}
+	assertBalances(t, s, map[int64]int64{from.Id: 1000})
+}
+
+func TestReverseTransfer(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, Config{})
+	from := createUser(t, s, "USD", 1000)
+	to := createUser(t, s, "EUR", 0)
+
+	transfer, err := s.TransferFunds(ctx, from.Id, to.Id, 400, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// refunds are in the sender's currency and paid back at the original rate
+	partial, err := s.ReverseTransfer(ctx, transfer.Id, 100)
+	if err != nil {
+		t.Fatalf("partial reversal: %v", err)
+	}
+	if partial.FromId != to.Id || partial.Amount != 50 || partial.Currency != "EUR" {
+		t.Errorf("partial reversal is %d %s from %d, want 50 EUR from %d", partial.Amount, partial.Currency, partial.FromId, to.Id)
+	}
+	assertBalances(t, s, map[int64]int64{from.Id: 700, to.Id: 150})
+
+	if _, err := s.ReverseTransfer(ctx, transfer.Id, 301); !errors.Is(err, repository.ErrReversalExceeded) {
+		t.Fatalf("over-reversal: error %v, want %v", err, repository.ErrReversalExceeded)
+	}
+	if _, err := s.ReverseTransfer(ctx, transfer.Id, 0); err != nil {
+		t.Fatalf("reversal of the rest: %v", err)
+	}
+	assertBalances(t, s, map[int64]int64{from.Id: 1000, to.Id: 0})
+
+	original, err := s.GetTransfer(ctx, transfer.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if original.Status != models.TransferStatusReversed || original.ReversedAmount != 400 {
+		t.Errorf("original is %s with %d reversed, want reversed in full", original.Status, original.ReversedAmount)
+	}
+	if _, err := s.ReverseTransfer(ctx, transfer.Id, 0); !errors.Is(err, repository.ErrAlreadyReversed) {
+		t.Errorf("reversal of a reversed transfer: error %v, want %v", err, repository.ErrAlreadyReversed)
+	}
+	if _, err := s.ReverseTransfer(ctx, partial.Id, 0); !errors.Is(err, repository.ErrNotReversible) {
+		t.Errorf("reversal of a reversal: error %v, want %v", err, repository.ErrNotReversible)
+	}
+}
+
+func TestDepositAndWithdraw(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, Config{})
+	user := createUser(t, s, "USD", 100)
+
+	if _, err := s.Deposit(ctx, user.Id, 50); err != nil {
+		t.Fatalf("Deposit: %v", err)
+	}
+	if _, err := s.Withdraw(ctx, user.Id, 151); !errors.Is(err, repository.ErrInsufficientFunds) {
+		t.Fatalf("Withdraw more than the balance: error %v, want %v", err, repository.ErrInsufficientFunds)
+	}
+	if _, err := s.Withdraw(ctx, user.Id, 150); err != nil {
+		t.Fatalf("Withdraw: %v", err)
+	}
+	assertBalances(t, s, map[int64]int64{user.Id: 0})
+}
+
+func TestDeleteUser(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, Config{})
+	funded := createUser(t, s, "USD", 100)
+	other := createUser(t, s, "USD", 0)
+	unused := createUser(t, s, "USD", 0)
+
+	var inUse *repository.AccountInUseError
+	if err := s.DeleteUser(ctx, funded.Id, 0); !errors.As(err, &inUse) || inUse.Balance != 100 {
+		t.Fatalf("delete a funded account: error %v, want it in use with a balance of 100", err)
+	}
+
+	// emptied, the account still has its history in the books
+	if _, err := s.TransferFunds(ctx, funded.Id, other.Id, 100, "USD"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteUser(ctx, funded.Id, 0); !errors.Is(err, repository.ErrAccountInUse) {
+		t.Fatalf("delete an account with history: error %v, want %v", err, repository.ErrAccountInUse)
+	}
+	if _, err := s.Withdraw(ctx, other.Id, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteUser(ctx, other.Id, 0); !errors.Is(err, repository.ErrAccountInUse) {
+		t.Fatalf("delete a recipient: error %v, want %v", err, repository.ErrAccountInUse)
+	}
+
+	if err := s.DeleteUser(ctx, unused.Id, unused.Version+1); !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Fatalf("delete a stale version: error %v, want %v", err, repository.ErrVersionMismatch)
+	}
+	if err := s.DeleteUser(ctx, unused.Id, unused.Version); err != nil {
+		t.Fatalf("delete an unused account: %v", err)
+	}
+	if _, err := s.GetUser(ctx, unused.Id); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("deleted account: error %v, want %v", err, repository.ErrNotFound)
+	}
+}
+
+func TestListTransfers(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, Config{})
+	a := createUser(t, s, "USD", 1000)
+	b := createUser(t, s, "USD", 1000)
+	c := createUser(t, s, "USD", 1000)
+
+	var made []*models.Transfer
+	for _, o := range []struct{ from, to, amount int64 }{
+		{a.Id, b.Id, 100},
+		{b.Id, a.Id, 50},
+		{a.Id, c.Id, 200},
+		// between two others, so never in a's history
+		{c.Id, b.Id, 300},
+		{b.Id, a.Id, 70},
+	} {
+		transfer, err := s.TransferFunds(ctx, o.from, o.to, o.amount, "USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+		made = append(made, transfer)
+	}
+	// ids picks transfers by their position in made
+	ids := func(i ...int) []int64 {
+		out := make([]int64, len(i))
+		for j, k := range i {
+			out[j] = made[k].Id
+		}
+		return out
+	}
+
+	tests := []struct {
+		name   string
+		filter models.TransferFilter
+		want   []int64
+	}{
+		{"newest first", models.TransferFilter{}, ids(4, 2, 1, 0)},
+		{"incoming", models.TransferFilter{Direction: models.TransferDirectionIn}, ids(4, 1)},
+		{"outgoing", models.TransferFilter{Direction: models.TransferDirectionOut}, ids(2, 0)},
+		{"since is inclusive", models.TransferFilter{Since: &made[2].CreatedAt}, ids(4, 2)},
+		{"until is exclusive", models.TransferFilter{Until: &made[2].CreatedAt}, ids(1, 0)},
+		{"amount range", models.TransferFilter{MinAmount: ptr(70), MaxAmount: ptr(100)}, ids(4, 0)},
+		{"nothing matches", models.TransferFilter{Direction: models.TransferDirectionIn, MinAmount: ptr(1000)}, ids()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// every page size walks the same history
+			for _, limit := range []int{1, 2, len(tt.want) + 1} {
+				filter := tt.filter
+				filter.UserId = a.Id
+				filter.Limit = limit
+				var got []int64
+				for pages := 0; ; pages++ {
+					if pages > len(tt.want) {
+						t.Fatalf("limit %d: cursors do not come to an end", limit)
+					}
+					page, err := s.ListTransfers(ctx, filter)
+					if err != nil {
+						t.Fatalf("limit %d: ListTransfers: %v", limit, err)
+					}
+					if len(page.Transfers) > limit {
+						t.Fatalf("limit %d: page of %d transfers", limit, len(page.Transfers))
+					}
+					for _, transfer := range page.Transfers {
+						got = append(got, transfer.Id)
+					}
+					if page.NextCursor == "" {
+						break
+					}
+					filter.Cursor = page.NextCursor
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("limit %d: got %v, want %v", limit, got, tt.want)
+				}
+			}
+		})
+	}
+
+	if _, err := s.ListTransfers(ctx, models.TransferFilter{UserId: c.Id + 100, Limit: 10}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("history of a missing user: error %v, want %v", err, repository.ErrNotFound)
+	}
+	if _, err := s.ListTransfers(ctx, models.TransferFilter{UserId: a.Id, Limit: 10, Cursor: "x"}); !errors.Is(err, repository.ErrInvalidCursor) {
+		t.Errorf("invalid cursor: error %v, want %v", err, repository.ErrInvalidCursor)
+	}
+}
+
+func TestGetTransfer(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, Config{})
+	from := createUser(t, s, "USD", 1000)
+	to := createUser(t, s, "USD", 0)
+
+	made, err := s.TransferFunds(ctx, from.Id, to.Id, 100, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetTransfer(ctx, made.Id)
+	if err != nil {
+		t.Fatalf("GetTransfer: %v", err)
+	}
+	if got.FromId != from.Id || got.ToId != to.Id || got.Amount != 100 || !got.CreatedAt.Equal(made.CreatedAt) {
+		t.Errorf("got %+v, want %+v", got, made)
+	}
+	for _, id := range []int64{0, -1, made.Id + 1} {
+		if _, err := s.GetTransfer(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetTransfer(%d): error %v, want %v", id, err, repository.ErrNotFound)
+		}
+	}
+}
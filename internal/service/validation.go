@@ -0,0 +1,147 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/lahaehae/crud_project/internal/currency"
+	"github.com/lahaehae/crud_project/internal/repository"
+)
+
+// DefaultMaxTransferAmount caps a single transfer when no limit is configured.
+const DefaultMaxTransferAmount = 100_000_000
+
+// DefaultHoldTTL is how long a hold lasts when no TTL is configured.
+const DefaultHoldTTL = 7 * 24 * time.Hour
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	// report fields by their JSON names, which is what API clients see
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+// normalizeEmail brings an address to the form it is stored and compared in.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+type userInput struct {
+	Name     string `json:"name" validate:"required,max=100"`
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Currency string `json:"currency" validate:"required"`
+	Balance  int64  `json:"balance" validate:"gte=0"`
+}
+
+type profileInput struct {
+	Name  string `json:"name" validate:"required,max=100"`
+	Email string `json:"email" validate:"required,email,max=254"`
+}
+
+type userPatchInput struct {
+	Name  *string `json:"name" validate:"omitnil,min=1,max=100"`
+	Email *string `json:"email" validate:"omitnil,email,max=254"`
+}
+
+type amountInput struct {
+	Amount int64 `json:"amount" validate:"gt=0"`
+}
+
+type transferInput struct {
+	FromId   int64  `json:"from_id" validate:"required,gt=0"`
+	ToId     int64  `json:"to_id" validate:"required,gt=0"`
+	Amount   int64  `json:"balance" validate:"gt=0"`
+	Currency string `json:"currency" validate:"required"`
+}
+
+type holdInput struct {
+	ToId     int64  `json:"to_id" validate:"required,gt=0"`
+	Amount   int64  `json:"amount" validate:"gt=0"`
+	Currency string `json:"currency" validate:"required"`
+}
+
+// captureInput allows zero, which captures the whole hold.
+type captureInput struct {
+	Amount int64 `json:"amount" validate:"gte=0"`
+}
+
+// reversalInput allows zero, which refunds whatever is left.
+type reversalInput struct {
+	Amount int64 `json:"amount" validate:"gte=0"`
+}
+
+// lookupCurrency returns the supported currency with code, or a field error
+// listing the supported ones.
+func lookupCurrency(code string) (currency.Currency, []repository.FieldError) {
+	cur, ok := currency.Lookup(code)
+	if !ok {
+		return cur, []repository.FieldError{{
+			Field:   "currency",
+			Message: "must be one of " + strings.Join(currency.Codes(), ", "),
+		}}
+	}
+	return cur, nil
+}
+
+// validateInput checks v against its validate tags and returns a
+// *repository.ValidationError listing every violation, including extra ones
+// found by checks that cannot be expressed as tags.
+func validateInput(v any, extra ...repository.FieldError) error {
+	verr := &repository.ValidationError{}
+	if err := validate.Struct(v); err != nil {
+		var fieldErrs validator.ValidationErrors
+		if !errors.As(err, &fieldErrs) {
+			return err
+		}
+		for _, fe := range fieldErrs {
+			verr.Fields = append(verr.Fields, repository.FieldError{
+				Field:   fe.Field(),
+				Message: ruleMessage(fe),
+			})
+		}
+	}
+	verr.Fields = append(verr.Fields, extra...)
+	if len(verr.Fields) == 0 {
+		return nil
+	}
+	return verr
+}
+
+func ruleMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		if fe.Kind() == reflect.String && fe.Param() == "1" {
+			return "must not be empty"
+		}
+		if fe.Kind() == reflect.String {
+			return "must be at least " + fe.Param() + " characters long"
+		}
+		return "must be at least " + fe.Param()
+	case "max":
+		if fe.Kind() == reflect.String {
+			return "must be at most " + fe.Param() + " characters long"
+		}
+		return "must be at most " + fe.Param()
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "gte":
+		return "must not be less than " + fe.Param()
+	default:
+		return "failed the " + fe.Tag() + " rule"
+	}
+}
@@ -0,0 +1,73 @@
+package telemetry
+
+import (
+	"context"
+	"strings"
+
+	"github.com/lahaehae/crud_project/internal/config"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// The endpoint may be given either as host:port or as a full URL. For a URL
+// the scheme decides whether TLS is used and the insecure setting is ignored.
+
+func isURL(endpoint string) bool {
+	return strings.Contains(endpoint, "://")
+}
+
+func newTraceExporter(ctx context.Context, cfg config.OTel) (sdktrace.SpanExporter, error) {
+	if cfg.Protocol == "grpc" {
+		opts := []otlptracegrpc.Option{}
+		if isURL(cfg.Endpoint) {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.Endpoint))
+		} else {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+			if cfg.Insecure {
+				opts = append(opts, otlptracegrpc.WithInsecure())
+			}
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	}
+
+	opts := []otlptracehttp.Option{}
+	if isURL(cfg.Endpoint) {
+		opts = append(opts, otlptracehttp.WithEndpointURL(strings.TrimSuffix(cfg.Endpoint, "/")+"/v1/traces"))
+	} else {
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+	}
+	return otlptracehttp.New(ctx, opts...)
+}
+
+func newMetricExporter(ctx context.Context, cfg config.OTel) (sdkmetric.Exporter, error) {
+	if cfg.Protocol == "grpc" {
+		opts := []otlpmetricgrpc.Option{}
+		if isURL(cfg.Endpoint) {
+			opts = append(opts, otlpmetricgrpc.WithEndpointURL(cfg.Endpoint))
+		} else {
+			opts = append(opts, otlpmetricgrpc.WithEndpoint(cfg.Endpoint))
+			if cfg.Insecure {
+				opts = append(opts, otlpmetricgrpc.WithInsecure())
+			}
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	}
+
+	opts := []otlpmetrichttp.Option{}
+	if isURL(cfg.Endpoint) {
+		opts = append(opts, otlpmetrichttp.WithEndpointURL(strings.TrimSuffix(cfg.Endpoint, "/")+"/v1/metrics"))
+	} else {
+		opts = append(opts, otlpmetrichttp.WithEndpoint(cfg.Endpoint))
+		if cfg.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+	}
+	return otlpmetrichttp.New(ctx, opts...)
+}
@@ -6,9 +6,10 @@ import (
 	"log"
 	"sync"
 
+	"github.com/lahaehae/crud_project/internal/config"
+
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
 	"go.opentelemetry.io/otel/metric"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
@@ -21,14 +22,12 @@ var (
 	LatencyRecorder  metric.Float64Histogram
 	ErrorCounter     metric.Int64Counter
 	RepoLatencyRecorder metric.Float64Histogram
+	TxRetryCounter   metric.Int64Counter
 )
 
 // Initializes an OTLP exporter, and configures the corresponding meter provider.
-func InitMeterProvider(ctx context.Context, res *resource.Resource) (func(context.Context) error, error) {
-	metricExporter, err := otlpmetrichttp.New(ctx, 
-		otlpmetrichttp.WithEndpoint("otel-collector:4318"),
-		otlpmetrichttp.WithInsecure(),
-	)
+func InitMeterProvider(ctx context.Context, res *resource.Resource, cfg config.OTel) (func(context.Context) error, error) {
+	metricExporter, err := newMetricExporter(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create metrics exporter: %w", err)
 	}
@@ -78,6 +77,14 @@ func InitMetrics() {
 		log.Printf("Ошибка создания счетчика ошибок")
 	}
 
+	TxRetryCounter, err = Meter.Int64Counter(
+		"db_tx_retries_total",
+		metric.WithDescription("Transactions re-run after a serialization failure or deadlock"),
+	)
+	if err != nil {
+		log.Printf("failed to create db_tx_retries_total counter")
+	}
+
 	})
 	
 }
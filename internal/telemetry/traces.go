@@ -4,20 +4,18 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/lahaehae/crud_project/internal/config"
+
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 // Initializes an OTLP exporter, and configures the corresponding trace provider.
-func InitTracerProvider(ctx context.Context, res *resource.Resource) (func(context.Context) error, error) {
+func InitTracerProvider(ctx context.Context, res *resource.Resource, cfg config.OTel) (func(context.Context) error, error) {
 	// Set up a trace exporter
-	traceExporter, err := otlptracehttp.New(ctx,
-		otlptracehttp.WithEndpoint("otel-collector:4318"),
-		otlptracehttp.WithInsecure(),
-	)
+	traceExporter, err := newTraceExporter(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
 	}
@@ -26,7 +24,7 @@ func InitTracerProvider(ctx context.Context, res *resource.Resource) (func(conte
 	// span processor to aggregate spans before export.
 	bsp := sdktrace.NewBatchSpanProcessor(traceExporter)
 	tracerProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SamplingRatio))),
 		sdktrace.WithResource(res),
 		sdktrace.WithSpanProcessor(bsp),
 	)